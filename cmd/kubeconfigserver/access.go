@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Per-identity access rules for config files, loaded from YAML file AUTH_RULES.
+
+rules:
+  - identities: ["cert:orders.payments.svc", "cert:spiffe://cluster.local/ns/payments/sa/orders"]
+    applications: [orders, "shared:*:main"]
+  - identities: ["k8s:group:system:serviceaccounts:billing", "basic:alice"]
+    applications: [billing]
+  - identities: ["*"]
+    applications: [public]
+
+Identities come from the authenticated principal (see auth.go), prefixed by
+authentication method: "basic:<user>", "token:<name>", "jwt:<sub>",
+"k8s:<ServiceAccount user name>" or "cert:<certificate identity>", plus
+"<method>:group:<name>" for each group. The identity "*" matches any authenticated client.
+
+Applications follow the same pattern syntax as refresh destinations (see refreshMatch),
+hence "orders" means "orders:**". A request is allowed only when every file
+referenced by the path is allowed for any of the client identities.
+
+Each file resolves to exactly one application, split at the last "-" like
+Spring Cloud Config Server does (see parseKey): "/payments-admin-prod.yml" is
+application "payments-admin", which rule "payments" does not allow.
+*/
+
+type accessRule struct {
+	Identities   []string `yaml:"identities"`
+	Applications []string `yaml:"applications"`
+}
+
+type accessRules struct {
+	Rules []accessRule `yaml:"rules"`
+}
+
+func loadAccessRules(file string) (*accessRules, error) {
+	data, errRead := os.ReadFile(file)
+	if errRead != nil {
+		return nil, errRead
+	}
+	var rules accessRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	log.Printf("access: loaded %d rules from %s", len(rules.Rules), file)
+	return &rules, nil
+}
+
+// allowed checks if any of identities may read path.
+func (r *accessRules) allowed(identities []string, path string) bool {
+	ids := parseKey(path)
+	if len(ids) == 0 {
+		return false
+	}
+	for _, id := range ids {
+		if !r.allowedID(identities, id) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowedID checks if any of identities may read application id [application profile label].
+func (r *accessRules) allowedID(identities []string, id []string) bool {
+	for _, rule := range r.Rules {
+		if !ruleIdentity(rule, identities) {
+			continue
+		}
+		for _, app := range rule.Applications {
+			if matchSegments(destinationPattern(app), id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ruleIdentity(rule accessRule, identities []string) bool {
+	if len(identities) == 0 {
+		return false // no verified identity
+	}
+	for _, ruleID := range rule.Identities {
+		if ruleID == "*" {
+			return true
+		}
+		for _, id := range identities {
+			if id == ruleID {
+				return true
+			}
+		}
+	}
+	return false
+}
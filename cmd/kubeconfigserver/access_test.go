@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccessRules(t *testing.T) {
+	const rulesYAML = `
+rules:
+  - identities: ["token:orders", "cert:spiffe://cluster.local/ns/payments/sa/orders"]
+    applications: [orders, "shared:*:main"]
+  - identities: ["*"]
+    applications: [public]
+`
+	file := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(file, []byte(rulesYAML), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	rules, errLoad := loadAccessRules(file)
+	if errLoad != nil {
+		t.Fatalf("load: %v", errLoad)
+	}
+
+	for _, data := range []struct {
+		identities []string
+		path       string
+		expected   bool
+	}{
+		{[]string{"token:orders"}, "/orders-dev.yml", true},
+		{[]string{"token:orders"}, "/orders/dev,prod", true},
+		{[]string{"cert:x", "cert:spiffe://cluster.local/ns/payments/sa/orders"}, "/orders-dev.yml", true},
+		{[]string{"basic:orders"}, "/orders-dev.yml", false}, // same name, other method
+		{[]string{"token:orders"}, "/main/shared-prod.yml", true},
+		{[]string{"token:orders"}, "/shared-prod.yml", false}, // missing label main
+		{[]string{"token:orders"}, "/billing-dev.yml", false},
+		{[]string{"token:orders"}, "/orders-dev.yml,billing-dev.yml", false}, // every file must be allowed
+		{[]string{"token:billing"}, "/public-dev.yml", true},
+		{[]string{"token:billing"}, "/orders-dev.yml", false},
+		{nil, "/public-dev.yml", false}, // no verified identity
+		{[]string{"token:orders"}, "/", false},
+		{[]string{"token:orders"}, "/orders/dev/1.0.x", true},            // dotted label
+		{[]string{"token:orders"}, "/orders-admin-prod.yml", false},      // application orders-admin
+		{[]string{"token:orders"}, "/main/orders-admin-prod.yml", false}, // application orders-admin
+		{[]string{"token:orders"}, "/orders-prod.yml,orders-admin-prod.yml", false},
+		{[]string{"token:orders"}, "/shared/prod/main", true},
+	} {
+		result := rules.allowed(data.identities, data.path)
+		if result != data.expected {
+			t.Errorf("identities=%v path=%s expected=%t got=%t", data.identities, data.path, data.expected, result)
+		}
+	}
+}
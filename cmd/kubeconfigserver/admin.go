@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Admin endpoints on the health server, under ADMIN_PATH (default /admin).
+
+curl -H "Authorization: Bearer $ADMIN_TOKEN" localhost:8888/admin/peers
+
+Refresh history, peer status and readiness details reveal application names,
+pod addresses and backend errors, hence they require ADMIN_TOKEN. Without
+ADMIN_TOKEN the admin endpoints are disabled. The liveness and readiness
+probes stay open for the kubelet, but readiness only reports check details
+to requests carrying the token.
+*/
+
+type adminAuth struct {
+	token string
+}
+
+// authorized checks header Authorization against ADMIN_TOKEN.
+// Empty token authorizes nothing.
+func (a *adminAuth) authorized(c *gin.Context) bool {
+	if a == nil || a.token == "" {
+		return false
+	}
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// middleware rejects requests without ADMIN_TOKEN.
+func (a *adminAuth) middleware(c *gin.Context) {
+	if !a.authorized(c) {
+		log.Printf("admin: unauthorized: remote=%s path=%s", c.ClientIP(), c.Request.URL.Path)
+		c.Header("WWW-Authenticate", "Bearer")
+		c.String(http.StatusUnauthorized, "unauthorized")
+		c.Abort()
+	}
+}
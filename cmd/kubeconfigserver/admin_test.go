@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminAuth(t *testing.T) {
+	for _, data := range []struct {
+		token         string
+		authorization string
+		expected      int
+	}{
+		{"s3cr3t", "Bearer s3cr3t", http.StatusOK},
+		{"s3cr3t", "Bearer wrong", http.StatusUnauthorized},
+		{"s3cr3t", "s3cr3t", http.StatusUnauthorized},
+		{"s3cr3t", "", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusUnauthorized},
+	} {
+		admin := &adminAuth{token: data.token}
+		router := gin.New()
+		router.GET("/admin/peers", admin.middleware, func(c *gin.Context) { c.String(http.StatusOK, "peers") })
+
+		req := httptest.NewRequest(http.MethodGet, "/admin/peers", nil)
+		if data.authorization != "" {
+			req.Header.Set("Authorization", data.authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != data.expected {
+			t.Errorf("token=%q authorization=%q expected=%d got=%d", data.token, data.authorization, data.expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+/*
+Spring Cloud Bus refresh events over AMQP.
+
+Spring Cloud Bus with the RabbitMQ binder publishes events as JSON into the topic
+exchange springCloudBus. Every replica binds its own exclusive, auto-deleted queue
+to the exchange, since each replica only knows the keys it has served.
+
+Events re-published by any replica of ours (see republish.go) are ignored.
+*/
+
+// amqpBusExchange is the exchange used by Spring Cloud Bus.
+const amqpBusExchange = "springCloudBus"
+
+// amqpRefresher consumes AMQP refresh notifications.
+// Whenever the consumer channel is closed, the consumer is recreated
+// with exponential backoff, the health is marked as degraded and, if
+// fallbackTTL is defined, new cache entries expire after fallbackTTL
+// until the channel is back.
+//
+// AMQP is marked as up by the consumer itself, once its queue is bound and
+// consuming, and marked as down once its deliveries stop. Both happen in the
+// run goroutine, hence they never race.
+type amqpRefresher struct {
+	amqpURL     string
+	me          string
+	debug       bool
+	inv         *invalidator
+	health      *healthStatus
+	ttl         time.Duration
+	fallbackTTL time.Duration
+	connect     func(amqpURL string) (<-chan amqp.Delivery, io.Closer, error) // optional, defaults to amqpConsume
+}
+
+func (r *amqpRefresher) run() {
+	const (
+		minBackoff = time.Second
+		maxBackoff = time.Minute
+	)
+
+	backoff := minBackoff
+
+	r.health.degrade("amqp", "connecting")
+
+	for {
+		begin := time.Now()
+
+		errConsume := r.consume()
+
+		amqpUp.Set(0)
+		r.health.degrade("amqp", errConsume.Error())
+
+		if r.fallbackTTL > 0 {
+			log.Printf("amqp: falling back to TTL=%v for new cache entries", r.fallbackTTL)
+			r.inv.groups.setTTL(r.fallbackTTL)
+		}
+
+		if time.Since(begin) > maxBackoff {
+			backoff = minBackoff // connection was stable, restart backoff
+		}
+
+		log.Printf("amqp: %v, reconnecting in %v", errConsume, backoff)
+		time.Sleep(backoff)
+
+		amqpReconnects.Inc()
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// consume marks AMQP as up once the consumer is ready, then delivers
+// refresh notifications until the channel is closed.
+// It always returns non-nil error telling why consuming stopped.
+func (r *amqpRefresher) consume() error {
+	connect := r.connect
+	if connect == nil {
+		connect = amqpConsume
+	}
+
+	deliveries, conn, errConnect := connect(r.amqpURL)
+	if errConnect != nil {
+		return errConnect
+	}
+	defer conn.Close()
+
+	log.Printf("amqp: connected")
+	amqpUp.Set(1)
+	r.health.recover("amqp")
+	r.inv.groups.setTTL(r.ttl)
+
+	for d := range deliveries {
+		if r.debug {
+			log.Printf("amqp: delivery: %s", d.Body)
+		}
+		destination, found := parseBusEvent(d.Body, r.me)
+		if !found {
+			continue
+		}
+		r.inv.refreshAndPublish(context.TODO(), "amqp", destination)
+	}
+
+	return errors.New("refresh channel closed")
+}
+
+// amqpConsume binds an exclusive queue to the Spring Cloud Bus exchange
+// and starts consuming it. Closing the returned connection stops deliveries.
+func amqpConsume(amqpURL string) (<-chan amqp.Delivery, io.Closer, error) {
+	conn, errDial := amqp.Dial(amqpURL)
+	if errDial != nil {
+		return nil, nil, errDial
+	}
+
+	deliveries, errConsume := amqpBind(conn)
+	if errConsume != nil {
+		conn.Close()
+		return nil, nil, errConsume
+	}
+
+	return deliveries, conn, nil
+}
+
+func amqpBind(conn *amqp.Connection) (<-chan amqp.Delivery, error) {
+	ch, errChan := conn.Channel()
+	if errChan != nil {
+		return nil, errChan
+	}
+	if err := ch.ExchangeDeclare(amqpBusExchange, "topic", true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+	q, errQueue := ch.QueueDeclare("", false, true, true, false, nil)
+	if errQueue != nil {
+		return nil, errQueue
+	}
+	// "#" means receive notification for all applications
+	if err := ch.QueueBind(q.Name, "#", amqpBusExchange, false, nil); err != nil {
+		return nil, err
+	}
+	return ch.Consume(q.Name, "", true, true, false, false, nil)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type closeRecorder struct {
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestAmqpConsume(t *testing.T) {
+	events := &recordPublisher{}
+	health := newHealthStatus()
+	conn := &closeRecorder{}
+
+	deliveries := make(chan amqp.Delivery, 2)
+	deliveries <- amqp.Delivery{Body: []byte(`{"type":"RefreshRemoteApplicationEvent","originService":"spring:host","destinationService":"myapp:**","id":"1"}`)}
+	deliveries <- amqp.Delivery{Body: []byte(`{"type":"RefreshRemoteApplicationEvent","originService":"kubeconfigserver:other","destinationService":"own:**","id":"2"}`)}
+	close(deliveries)
+
+	var upWhileConsuming bool
+
+	r := &amqpRefresher{
+		me:     "kubeconfigserver",
+		health: health,
+		inv: &invalidator{
+			groups:      &cacheGroups{},
+			keys:        newTable(),
+			republisher: &republisher{origin: "test", publishers: []publisher{events}},
+		},
+	}
+
+	// connection failure leaves amqp degraded
+	health.degrade("amqp", "connecting")
+	r.connect = func(string) (<-chan amqp.Delivery, io.Closer, error) {
+		return nil, nil, errors.New("connection refused")
+	}
+	if err := r.consume(); err == nil {
+		t.Errorf("expected connection error")
+	}
+	if _, degraded := health.isDegraded("amqp"); !degraded {
+		t.Errorf("expected amqp degraded after connection failure")
+	}
+
+	// consumer marks amqp up once it is consuming
+	r.connect = func(string) (<-chan amqp.Delivery, io.Closer, error) {
+		return deliveries, conn, nil
+	}
+	r.inv.elect = func(string) bool {
+		_, degraded := health.isDegraded("amqp")
+		upWhileConsuming = !degraded
+		return true
+	}
+	if err := r.consume(); err == nil {
+		t.Errorf("expected error once deliveries stop")
+	}
+	if !upWhileConsuming {
+		t.Errorf("expected amqp up while consuming")
+	}
+	if !conn.closed {
+		t.Errorf("expected connection closed once deliveries stop")
+	}
+	if len(events.events) != 1 || events.events[0].DestinationService != "myapp:**" {
+		t.Errorf("expected one event for myapp:**, own event ignored, got: %+v", events.events)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// refreshRecord describes one refresh event.
+type refreshRecord struct {
+	Time        time.Time `json:"time"`
+	Host        string    `json:"host"`
+	Source      string    `json:"source"` // amqp, kafka, monitor, http, poll
+	Destination string    `json:"destination"`
+	Removed     []string  `json:"removed"`
+	Errors      []string  `json:"errors,omitempty"`
+}
+
+// refreshAudit keeps the most recent refresh events in a bounded ring.
+type refreshAudit struct {
+	host    string
+	mutex   sync.Mutex
+	records []refreshRecord
+	next    int // position for next record
+	full    bool
+}
+
+func newRefreshAudit(host string, size int) *refreshAudit {
+	if size < 1 {
+		size = 1
+	}
+	return &refreshAudit{
+		host:    host,
+		records: make([]refreshRecord, size),
+	}
+}
+
+// record saves refresh event and logs it as a single JSON line.
+func (a *refreshAudit) record(r refreshRecord) {
+	r.Time = time.Now()
+	r.Host = a.host
+
+	if buf, err := json.Marshal(r); err == nil {
+		log.Printf("refresh audit: %s", buf)
+	} else {
+		log.Printf("refresh audit: json error: %v", err)
+	}
+
+	a.mutex.Lock()
+	a.records[a.next] = r
+	a.next = (a.next + 1) % len(a.records)
+	if a.next == 0 {
+		a.full = true
+	}
+	a.mutex.Unlock()
+}
+
+// history returns recorded events, newest first.
+func (a *refreshAudit) history() []refreshRecord {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	count := a.next
+	if a.full {
+		count = len(a.records)
+	}
+
+	list := make([]refreshRecord, 0, count)
+	for i := 1; i <= count; i++ {
+		j := (a.next - i + len(a.records)) % len(a.records)
+		list = append(list, a.records[j])
+	}
+	return list
+}
+
+func (a *refreshAudit) handleHistory(c *gin.Context) {
+	c.JSON(http.StatusOK, a.history())
+}
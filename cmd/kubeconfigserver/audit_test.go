@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRefreshAuditRing(t *testing.T) {
+	a := newRefreshAudit("host", 3)
+
+	if h := a.history(); len(h) != 0 {
+		t.Errorf("expected empty history, got %v", h)
+	}
+
+	for i := 1; i <= 5; i++ {
+		a.record(refreshRecord{Source: "test", Destination: strconv.Itoa(i)})
+
+		h := a.history()
+		expectedLen := min(i, 3)
+		if len(h) != expectedLen {
+			t.Fatalf("record %d: expected history length %d, got %d", i, expectedLen, len(h))
+		}
+		// newest first
+		for j, r := range h {
+			if expected := strconv.Itoa(i - j); r.Destination != expected {
+				t.Errorf("record %d: history[%d] expected destination=%s got=%s", i, j, expected, r.Destination)
+			}
+			if r.Host != "host" {
+				t.Errorf("record %d: history[%d] expected host=host got=%s", i, j, r.Host)
+			}
+		}
+	}
+}
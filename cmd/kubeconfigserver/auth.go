@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+/*
+Client authentication and authorization for config files (HTTP /*anything and gRPC).
+
+Authentication methods, enabled by their config:
+
+- basic auth:         AUTH_BASIC_FILE, lines of "user:bcrypt-hash" (htpasswd -B)
+- static tokens:      AUTH_TOKENS_FILE, lines of "name:token", sent as "Authorization: Bearer <token>"
+- JWT/OIDC:           AUTH_JWKS_FILE, bearer JWT verified against local JWKS (see jwt.go)
+- Kubernetes tokens:  AUTH_KUBERNETES=true, bearer ServiceAccount token verified with TokenReview (see tokenreview.go)
+- client certificate: TLS_CLIENT_CA_FILE, see tls.go
+
+Once any method is enabled, unauthenticated requests are rejected.
+AUTH_RULES restricts which identities may read which applications (see access.go),
+otherwise any authenticated client may read everything.
+*/
+
+// principalKey stores authenticated principal in gin context.
+const principalKey = "principal"
+
+// principal is an authenticated client.
+//
+// Identities are prefixed by the authentication method, so that names from
+// different methods never collide: "basic:alice", "token:ci", "jwt:alice",
+// "k8s:system:serviceaccount:payments:orders", "cert:orders.payments.svc".
+// Groups are "<method>:group:<name>", like "jwt:group:admins".
+type principal struct {
+	method     string   // basic, token, jwt, k8s, cert
+	identities []string // user name, followed by groups
+}
+
+func newPrincipal(method string, names, groups []string) *principal {
+	p := &principal{method: method}
+	for _, n := range names {
+		p.identities = append(p.identities, method+":"+n)
+	}
+	for _, g := range groups {
+		p.identities = append(p.identities, method+":group:"+g)
+	}
+	return p
+}
+
+func (p *principal) String() string {
+	return strings.Join(p.identities, ",")
+}
+
+// errUnauthenticated rejects requests without valid credentials.
+var errUnauthenticated = errors.New("unauthenticated")
+
+// authenticator verifies bearer token or basic credentials from header Authorization.
+// It returns nil principal, nil error for credentials it doesn't handle.
+type authenticator interface {
+	authenticate(ctx context.Context, authorization string) (*principal, error)
+}
+
+type auth struct {
+	authenticators []authenticator
+	clientCert     bool         // accept verified client certificates
+	rules          *accessRules // optional
+	basicRealm     bool         // challenge with basic auth
+}
+
+// newAuth creates auth from config.
+// It returns nil when no authentication method is enabled.
+func newAuth(config appConfig, tlsConfig *tls.Config) (*auth, error) {
+	a := &auth{
+		clientCert: tlsConfig != nil && tlsConfig.ClientCAs != nil,
+	}
+
+	if config.authBasicFile != "" {
+		b, err := loadBasicAuth(config.authBasicFile)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_BASIC_FILE: %w", err)
+		}
+		a.authenticators = append(a.authenticators, b)
+		a.basicRealm = true
+	}
+
+	if config.authTokensFile != "" {
+		t, err := loadStaticTokens(config.authTokensFile)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_TOKENS_FILE: %w", err)
+		}
+		a.authenticators = append(a.authenticators, t)
+	}
+
+	if config.authJwksFile != "" {
+		j, err := newJwtAuth(config.authJwksFile, config.authJwtIssuer, config.authJwtAudience, config.authJwtGroupsClaim)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_JWKS_FILE: %w", err)
+		}
+		a.authenticators = append(a.authenticators, j)
+	}
+
+	if config.authKubernetes {
+		k, err := newTokenReviewAuth(config.authKubernetesAudiences)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_KUBERNETES: %w", err)
+		}
+		a.authenticators = append(a.authenticators, k)
+	}
+
+	if config.authRules != "" {
+		rules, err := loadAccessRules(config.authRules)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_RULES: %w", err)
+		}
+		a.rules = rules
+	}
+
+	if len(a.authenticators) == 0 && !a.clientCert {
+		if a.rules != nil {
+			return nil, errors.New("AUTH_RULES requires an authentication method")
+		}
+		return nil, nil // authentication disabled
+	}
+
+	log.Printf("auth: authenticators=%d client_cert=%t rules=%t", len(a.authenticators), a.clientCert, a.rules != nil)
+
+	return a, nil
+}
+
+// authenticate finds principal from header Authorization, or else from client certificate.
+func (a *auth) authenticate(ctx context.Context, authorization string, state *tls.ConnectionState) (*principal, error) {
+	if authorization != "" {
+		for _, au := range a.authenticators {
+			p, err := au.authenticate(ctx, authorization)
+			if err != nil {
+				return nil, err
+			}
+			if p != nil {
+				return p, nil
+			}
+		}
+		return nil, errUnauthenticated
+	}
+	if a.clientCert {
+		if ids := certIdentities(state); len(ids) > 0 {
+			return newPrincipal("cert", ids, nil), nil
+		}
+	}
+	return nil, errUnauthenticated
+}
+
+// allowed checks if principal may read path.
+func (a *auth) allowed(p *principal, path string) bool {
+	if a.rules == nil {
+		return true
+	}
+	return a.rules.allowed(p.identities, path)
+}
+
+// middleware rejects unauthenticated requests and requests for paths not allowed to the principal.
+func (a *auth) middleware(c *gin.Context) {
+	p, errAuth := a.authenticate(c.Request.Context(), c.GetHeader("Authorization"), c.Request.TLS)
+	if errAuth != nil {
+		log.Printf("auth: remote=%s: %v", c.ClientIP(), errAuth)
+		if a.basicRealm {
+			c.Header("WWW-Authenticate", `Basic realm="config"`)
+		} else {
+			c.Header("WWW-Authenticate", "Bearer")
+		}
+		c.String(http.StatusUnauthorized, "unauthorized")
+		c.Abort()
+		return
+	}
+	for _, path := range append([]string{c.Param("anything")}, c.QueryArray("path")...) {
+		if !a.allowed(p, path) {
+			log.Printf("auth: denied path='%s' principal=%s remote=%s", path, p, c.ClientIP())
+			c.String(http.StatusForbidden, "forbidden")
+			c.Abort()
+			return
+		}
+	}
+	c.Set(principalKey, p)
+}
+
+// authorizeGrpc authenticates gRPC request and checks if the principal may read paths.
+// It returns nil principal when authentication is disabled.
+func (a *auth) authorizeGrpc(ctx context.Context, paths ...string) (*principal, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	var authorization string
+	if md, found := metadata.FromIncomingContext(ctx); found {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+
+	var state *tls.ConnectionState
+	if p, found := peer.FromContext(ctx); found {
+		if info, isTLS := p.AuthInfo.(credentials.TLSInfo); isTLS {
+			state = &info.State
+		}
+	}
+
+	p, errAuth := a.authenticate(ctx, authorization, state)
+	if errAuth != nil {
+		log.Printf("auth: grpc: %v", errAuth)
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+
+	for _, path := range paths {
+		if !a.allowed(p, path) {
+			log.Printf("auth: grpc: denied path='%s' principal=%s", path, p)
+			return nil, status.Error(codes.PermissionDenied, "forbidden")
+		}
+	}
+
+	return p, nil
+}
+
+// readCredentials reads lines of "name:secret" from file, skipping blank lines and comments.
+func readCredentials(file string) (map[string]string, error) {
+	f, errOpen := os.Open(file)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer f.Close()
+	creds := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, secret, found := strings.Cut(line, ":")
+		if !found || name == "" || secret == "" {
+			return nil, fmt.Errorf("%s: line %d: expected name:secret", file, i)
+		}
+		creds[name] = secret
+	}
+	return creds, scanner.Err()
+}
+
+// basicAuth verifies basic auth credentials against bcrypt hashes.
+type basicAuth struct {
+	hashes map[string]string // user => bcrypt hash
+	dummy  []byte            // compared for unknown users, not to leak user names through timing
+}
+
+func loadBasicAuth(file string) (*basicAuth, error) {
+	hashes, err := readCredentials(file)
+	if err != nil {
+		return nil, err
+	}
+	cost := bcrypt.DefaultCost
+	for _, hash := range hashes {
+		if c, errCost := bcrypt.Cost([]byte(hash)); errCost == nil {
+			cost = c
+		}
+		break
+	}
+	dummy, errDummy := bcrypt.GenerateFromPassword([]byte("dummy"), cost)
+	if errDummy != nil {
+		return nil, errDummy
+	}
+	log.Printf("auth: basic: loaded %d users from %s", len(hashes), file)
+	return &basicAuth{hashes: hashes, dummy: dummy}, nil
+}
+
+func (b *basicAuth) authenticate(_ context.Context, authorization string) (*principal, error) {
+	r := http.Request{Header: http.Header{"Authorization": []string{authorization}}}
+	user, password, found := r.BasicAuth()
+	if !found {
+		return nil, nil // not basic auth
+	}
+	hash, exists := b.hashes[user]
+	if !exists {
+		bcrypt.CompareHashAndPassword(b.dummy, []byte(password))
+		return nil, fmt.Errorf("basic: unknown user '%s'", user)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return nil, fmt.Errorf("basic: user '%s': bad password", user)
+	}
+	return newPrincipal("basic", []string{user}, nil), nil
+}
+
+// staticTokens verifies bearer tokens against a fixed list.
+type staticTokens struct {
+	tokens map[[sha256.Size]byte]string // token hash => name
+}
+
+func loadStaticTokens(file string) (*staticTokens, error) {
+	creds, err := readCredentials(file)
+	if err != nil {
+		return nil, err
+	}
+	t := &staticTokens{tokens: map[[sha256.Size]byte]string{}}
+	for name, token := range creds {
+		t.tokens[sha256.Sum256([]byte(token))] = name
+	}
+	log.Printf("auth: tokens: loaded %d tokens from %s", len(t.tokens), file)
+	return t, nil
+}
+
+func (t *staticTokens) authenticate(_ context.Context, authorization string) (*principal, error) {
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found {
+		return nil, nil // not bearer token
+	}
+	// lookup by hash, not to leak token through timing
+	if name, exists := t.tokens[sha256.Sum256([]byte(token))]; exists {
+		return newPrincipal("token", []string{name}, nil), nil
+	}
+	return nil, nil // maybe JWT or kubernetes token
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		sig = s
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return file
+}
+
+func TestJwtAuth(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa1", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+	}})
+	file := writeFile(t, t.TempDir(), "jwks.json", string(jwks))
+
+	if _, err := newJwtAuth(file, "https://issuer", "", "groups"); err == nil {
+		t.Errorf("expected error for missing audience")
+	}
+
+	j, errNew := newJwtAuth(file, "https://issuer", "config", "groups")
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	good := map[string]any{"sub": "alice", "iss": "https://issuer", "aud": []string{"config"}, "exp": exp, "groups": []string{"dev"}}
+
+	for _, data := range []struct {
+		name     string
+		token    string
+		expected string // principal, or empty for not handled
+		fail     bool
+	}{
+		{"rs256", signJWT(t, "RS256", "rsa1", rsaKey, good), "jwt:alice,jwt:group:dev", false},
+		{"es256", signJWT(t, "ES256", "ec1", ecKey, good), "jwt:alice,jwt:group:dev", false},
+		{"wrong key", signJWT(t, "RS256", "ec1", rsaKey, good), "", true},
+		{"unknown kid", signJWT(t, "RS256", "other", rsaKey, good), "", false},
+		{"expired", signJWT(t, "RS256", "rsa1", rsaKey, map[string]any{"sub": "alice", "iss": "https://issuer", "aud": "config", "exp": float64(time.Now().Add(-time.Hour).Unix())}), "", true},
+		{"bad issuer", signJWT(t, "RS256", "rsa1", rsaKey, map[string]any{"sub": "alice", "iss": "other", "aud": "config", "exp": exp}), "", true},
+		{"bad audience", signJWT(t, "RS256", "rsa1", rsaKey, map[string]any{"sub": "alice", "iss": "https://issuer", "aud": "other", "exp": exp}), "", true},
+		{"missing audience", signJWT(t, "RS256", "rsa1", rsaKey, map[string]any{"sub": "alice", "iss": "https://issuer", "exp": exp}), "", true},
+		{"not jwt", "opaque-token", "", false},
+		{"unsupported alg", signJWT(t, "HS256", "rsa1", rsaKey, good), "", false},
+	} {
+		p, err := j.authenticate(context.Background(), "Bearer "+data.token)
+		if data.fail {
+			if err == nil {
+				t.Errorf("%s: expected error", data.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", data.name, err)
+			continue
+		}
+		var result string
+		if p != nil {
+			result = p.String()
+		}
+		if result != data.expected {
+			t.Errorf("%s: expected principal %q, got %q", data.name, data.expected, result)
+		}
+	}
+}
+
+type fakeReviewer struct {
+	calls int
+}
+
+func (f *fakeReviewer) Create(_ context.Context, review *authenticationv1.TokenReview, _ metav1.CreateOptions) (*authenticationv1.TokenReview, error) {
+	f.calls++
+	switch review.Spec.Token {
+	case "sa-token":
+		review.Status.Authenticated = true
+		review.Status.User.Username = "system:serviceaccount:payments:orders"
+		review.Status.User.Groups = []string{"system:serviceaccounts:payments"}
+	case "api-down":
+		return nil, errors.New("connection refused")
+	}
+	return review, nil
+}
+
+func TestTokenReviewAuth(t *testing.T) {
+	reviewer := &fakeReviewer{}
+	k := &tokenReviewAuth{reviewer: reviewer, cache: map[[sha256.Size]byte]tokenReviewEntry{}}
+
+	for i := 0; i < 2; i++ {
+		p, err := k.authenticate(context.Background(), "Bearer sa-token")
+		if err != nil {
+			t.Fatalf("authenticate: %v", err)
+		}
+		if expected := "k8s:system:serviceaccount:payments:orders,k8s:group:system:serviceaccounts:payments"; p.String() != expected {
+			t.Errorf("expected %s, got %s", expected, p)
+		}
+	}
+	if reviewer.calls != 1 {
+		t.Errorf("expected cached review, got %d calls", reviewer.calls)
+	}
+
+	if _, err := k.authenticate(context.Background(), "Bearer bad-token"); err == nil {
+		t.Errorf("bad token: expected error")
+	}
+	if _, err := k.authenticate(context.Background(), "Bearer api-down"); err == nil {
+		t.Errorf("api down: expected error")
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	dir := t.TempDir()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	basic, errBasic := loadBasicAuth(writeFile(t, dir, "users", "# users\nalice:"+string(hash)+"\n"))
+	if errBasic != nil {
+		t.Fatalf("basic: %v", errBasic)
+	}
+	tokens, errTokens := loadStaticTokens(writeFile(t, dir, "tokens", "ci:ci-token\n"))
+	if errTokens != nil {
+		t.Fatalf("tokens: %v", errTokens)
+	}
+	rules, errRules := loadAccessRules(writeFile(t, dir, "rules.yaml", `
+rules:
+  - identities: ["basic:alice"]
+    applications: [orders]
+  - identities: ["*"]
+    applications: [public]
+`))
+	if errRules != nil {
+		t.Fatalf("rules: %v", errRules)
+	}
+
+	a := &auth{authenticators: []authenticator{basic, tokens}, rules: rules, basicRealm: true}
+
+	router := gin.New()
+	router.GET("/*anything", a.middleware, func(c *gin.Context) {
+		p, _ := c.Get(principalKey)
+		c.String(http.StatusOK, p.(*principal).String())
+	})
+
+	for _, data := range []struct {
+		path     string
+		user     string
+		password string
+		token    string
+		status   int
+	}{
+		{"/orders-dev.yml", "alice", "secret", "", http.StatusOK},
+		{"/orders-dev.yml", "alice", "wrong", "", http.StatusUnauthorized},
+		{"/orders-dev.yml", "bob", "secret", "", http.StatusUnauthorized},
+		{"/orders-dev.yml", "", "", "", http.StatusUnauthorized},
+		{"/billing-dev.yml", "alice", "secret", "", http.StatusForbidden},
+		{"/public-dev.yml", "", "", "ci-token", http.StatusOK},
+		{"/orders-dev.yml", "", "", "ci-token", http.StatusForbidden},
+		{"/public-dev.yml", "", "", "wrong-token", http.StatusUnauthorized},
+		{"/public-dev.yml?path=/orders-dev.yml", "", "", "ci-token", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, data.path, nil)
+		if data.user != "" {
+			req.SetBasicAuth(data.user, data.password)
+		}
+		if data.token != "" {
+			req.Header.Set("Authorization", "Bearer "+data.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != data.status {
+			t.Errorf("path=%s user=%s token=%s: expected status %d, got %d",
+				data.path, data.user, data.token, data.status, w.Code)
+		}
+	}
+}
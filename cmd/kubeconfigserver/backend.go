@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -22,11 +23,21 @@ type backendError struct {
 	err    error
 }
 
+// statusTooLarge marks backend objects exceeding MAX_OBJECT_SIZE.
+// It is not sent to clients, since their request is fine: they get 502.
+const statusTooLarge = http.StatusRequestEntityTooLarge
+
+// errTooLarge is reported for objects exceeding MAX_OBJECT_SIZE.
+var errTooLarge = errors.New("object too large")
+
 func sendBackendError(c *gin.Context, err backendError) {
 	switch err.status {
 	case http.StatusNotFound:
 		c.String(http.StatusNotFound, "not found")
 		return
+	case statusTooLarge:
+		c.String(http.StatusBadGateway, "backend object exceeds MAX_OBJECT_SIZE")
+		return
 	}
 	c.String(http.StatusBadGateway, "error status from backend: %d", err.status)
 }
@@ -66,24 +77,48 @@ type backend interface {
 	fetch(ctx context.Context, path string) ([]byte, error)
 }
 
-func newBackend(tracer trace.Tracer, address, options string) backend {
+// newBackend creates backend for address.
+// maxSize limits the size of fetched objects, zero means unlimited.
+func newBackend(tracer trace.Tracer, address, options string, maxSize int64) backend {
 	if dir := strings.TrimPrefix(address, "dir:"); dir != address {
-		log.Printf("backend: %s: dir", address)
-		return newBackendDir(tracer, dir, options)
+		log.Printf("backend: %s: dir maxSize=%d", address, maxSize)
+		return newBackendDir(tracer, dir, options, maxSize)
+	}
+	log.Printf("backend: %s: http maxSize=%d", address, maxSize)
+	return &backendHTTP{tracer: tracer, host: address, maxSize: maxSize}
+}
+
+// readLimited reads up to maxSize bytes from r.
+// It reports errTooLarge if r holds more than maxSize bytes.
+// Zero maxSize means unlimited.
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize < 1 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return data, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errTooLarge
 	}
-	log.Printf("backend: %s: http", address)
-	return &backendHTTP{tracer: tracer, host: address}
+	return data, nil
+}
+
+func tooLarge(size, maxSize int64) bool {
+	return maxSize > 0 && size > maxSize
 }
 
 type backendDir struct {
 	tracer  trace.Tracer
 	dir     string
 	flatten bool // strip directory prefixes from requested path
+	maxSize int64
 }
 
-func newBackendDir(tracer trace.Tracer, dir, options string) *backendDir {
+func newBackendDir(tracer trace.Tracer, dir, options string, maxSize int64) *backendDir {
 	flatten := strings.Contains(options, "flatten")
-	return &backendDir{tracer: tracer, dir: dir, flatten: flatten}
+	return &backendDir{tracer: tracer, dir: dir, flatten: flatten, maxSize: maxSize}
 }
 
 func (b *backendDir) fetch(ctx context.Context, path string) ([]byte, error) {
@@ -98,8 +133,12 @@ func (b *backendDir) fetch(ctx context.Context, path string) ([]byte, error) {
 		filename = path
 	}
 	fullpath := filepath.Join(b.dir, filename)
-	data, err := os.ReadFile(fullpath)
-	if err != nil && strings.Contains(err.Error(), "no such file or directory") {
+	data, err := b.readFile(fullpath)
+	switch {
+	case err == errTooLarge:
+		status = statusTooLarge
+		backendTooLarge.WithLabelValues("dir").Inc()
+	case err != nil && strings.Contains(err.Error(), "no such file or directory"):
 		status = http.StatusNotFound
 	}
 	log.Printf("backendDir: flatten=%t path='%s' filename='%s' fullpath='%s' size=%d status=%d error:%v",
@@ -113,9 +152,34 @@ func (b *backendDir) fetch(ctx context.Context, path string) ([]byte, error) {
 	return data, be
 }
 
+func (b *backendDir) readFile(fullpath string) ([]byte, error) {
+	f, errOpen := os.Open(fullpath)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer f.Close()
+	if info, errStat := f.Stat(); errStat == nil && tooLarge(info.Size(), b.maxSize) {
+		return nil, errTooLarge
+	}
+	return readLimited(f, b.maxSize)
+}
+
+// check verifies the backend directory exists.
+func (b *backendDir) check(_ context.Context) error {
+	info, err := os.Stat(b.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory: %s", b.dir)
+	}
+	return nil
+}
+
 type backendHTTP struct {
-	tracer trace.Tracer
-	host   string
+	tracer  trace.Tracer
+	host    string
+	maxSize int64
 }
 
 func (b *backendHTTP) fetch(ctx context.Context, path string) ([]byte, error) {
@@ -151,8 +215,18 @@ func (b *backendHTTP) fetch(ctx context.Context, path string) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 	status = resp.StatusCode
-	data, errRead := io.ReadAll(resp.Body)
+	var data []byte
+	var errRead error
+	if tooLarge(resp.ContentLength, b.maxSize) {
+		errRead = errTooLarge
+	} else {
+		data, errRead = readLimited(resp.Body, b.maxSize)
+	}
 	resp.Body.Close()
+	if errRead == errTooLarge {
+		status = statusTooLarge
+		backendTooLarge.WithLabelValues("http").Inc()
+	}
 	log.Printf("backendHTTP: path='%s' url='%s' size=%d status=%d error:%v",
 		path, u, len(data), status, errRead)
 	be := newBackendError(status, errRead)
@@ -161,3 +235,19 @@ func (b *backendHTTP) fetch(ctx context.Context, path string) ([]byte, error) {
 	}
 	return data, be
 }
+
+// check verifies the backend answers HTTP requests.
+// Any response status means reachable.
+func (b *backendHTTP) check(ctx context.Context) error {
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodHead, b.host, nil)
+	if errReq != nil {
+		return errReq
+	}
+	client := http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	resp, errDo := client.Do(req)
+	if errDo != nil {
+		return errDo
+	}
+	resp.Body.Close()
+	return nil
+}
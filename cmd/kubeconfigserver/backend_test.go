@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestReadLimited(t *testing.T) {
+	for _, data := range []struct {
+		size     int
+		maxSize  int64
+		tooLarge bool
+	}{
+		{10, 0, false},
+		{10, 10, false},
+		{10, 11, false},
+		{11, 10, true},
+		{1000, 10, true},
+	} {
+		input := strings.Repeat("x", data.size)
+		result, err := readLimited(strings.NewReader(input), data.maxSize)
+		if data.tooLarge {
+			if err != errTooLarge {
+				t.Errorf("size=%d maxSize=%d expected errTooLarge, got: %v", data.size, data.maxSize, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("size=%d maxSize=%d unexpected error: %v", data.size, data.maxSize, err)
+			continue
+		}
+		if !bytes.Equal(result, []byte(input)) {
+			t.Errorf("size=%d maxSize=%d data mismatch", data.size, data.maxSize)
+		}
+	}
+}
+
+func TestBackendDirTooLarge(t *testing.T) {
+	tracer := trace.NewNoopTracerProvider().Tracer("test")
+	b := newBackendDir(tracer, "../../samples", "", 10)
+	_, err := b.fetch(context.Background(), "deploy.yml")
+	be, isBackend := err.(backendError)
+	if !isBackend || be.status != statusTooLarge {
+		t.Errorf("expected status %d, got: %v", statusTooLarge, err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	sendBackendError(c, be)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected client status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/groupcache"
+	pb "github.com/mailgun/groupcache/groupcachepb"
+)
+
+/*
+HTTP refresh endpoint, an alternative to AMQP refresh notifications.
+
+curl -X POST -H "Authorization: Bearer $BUSREFRESH_TOKEN" localhost:8080/actuator/busrefresh/myapp:**
+
+The request is broadcast to all groupcache peers, since each peer only knows
+the keys it has served. Peers are reached through the groupcache peer protocol,
+by removing the key "<source>/<destination>" from the pseudo group busRefreshGroup.
+Such removal requests are intercepted by peerHandler before reaching the groupcache pool.
+The git webhook monitor uses the same broadcast, with source "monitor".
+
+Since the groupcache listener has no authentication, broadcast requests carry
+header busRefreshSignatureHeader, the HMAC-SHA256 of the key with peerSecret,
+and peerHandler rejects requests without a valid signature.
+*/
+
+// busRefreshGroup is the pseudo group used to broadcast refresh requests to peers.
+const busRefreshGroup = "_busrefresh"
+
+// busRefreshSignatureHeader carries the signature of broadcast requests.
+const busRefreshSignatureHeader = "X-Busrefresh-Signature"
+
+type busRefresh struct {
+	inv        *invalidator
+	pool       *groupcache.HTTPPool
+	token      string
+	peerSecret string // signs requests among peers, empty rejects them all
+}
+
+func newBusRefresh(inv *invalidator, pool *groupcache.HTTPPool, token, peerSecret string) *busRefresh {
+	return &busRefresh{inv: inv, pool: pool, token: token, peerSecret: peerSecret}
+}
+
+type busRefreshResponse struct {
+	Destination string   `json:"destination"`
+	Peers       int      `json:"peers"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+// handle serves POST /actuator/busrefresh and POST /actuator/busrefresh/:destination
+func (b *busRefresh) handle(c *gin.Context) {
+	if !b.authorized(c.GetHeader("Authorization")) {
+		log.Printf("busrefresh: unauthorized request from %s", c.ClientIP())
+		c.String(http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	destination := c.Param("destination")
+	if destination == "" {
+		destination = "*:**" // refresh all applications
+	}
+
+	peers, errs := b.broadcast(c.Request.Context(), "http", destination)
+
+	// peers have re-fetched their keys, which are unknown here
+	b.inv.publish(c.Request.Context(), destination, nil)
+
+	resp := busRefreshResponse{
+		Destination: destination,
+		Peers:       peers,
+	}
+	for _, err := range errs {
+		resp.Errors = append(resp.Errors, err.Error())
+	}
+
+	status := http.StatusOK
+	if len(errs) > 0 {
+		status = http.StatusBadGateway
+	}
+	c.JSON(status, resp)
+}
+
+func (b *busRefresh) authorized(header string) bool {
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(b.token)) == 1
+}
+
+// broadcast sends refresh request for destination to all peers, including ourselves.
+// source tells where the request came from, like "http" or "monitor".
+// Each peer removes and re-fetches its keys before replying, hence
+// the refresh event can be re-published once broadcast returns.
+func (b *busRefresh) broadcast(ctx context.Context, source, destination string) (int, []error) {
+	peers := b.pool.GetAll()
+	if len(peers) == 0 {
+		// peers not discovered yet
+		b.inv.refreshAndFetch(ctx, source, destination)
+		return 0, nil
+	}
+
+	group := busRefreshGroup
+	key := source + "/" + destination
+	req := &pb.GetRequest{Group: &group, Key: &key}
+
+	ctx = withPeerHeader(ctx, busRefreshSignatureHeader, b.sign(key))
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var errs []error
+
+	for _, p := range peers {
+		wg.Add(1)
+		go func(peer groupcache.ProtoGetter) {
+			defer wg.Done()
+			if err := peer.Remove(ctx, req); err != nil {
+				log.Printf("busrefresh: destination='%s' peer error: %v", destination, err)
+				mutex.Lock()
+				errs = append(errs, err)
+				mutex.Unlock()
+			}
+		}(p)
+	}
+
+	wg.Wait()
+
+	return len(peers), errs
+}
+
+// peerHandler intercepts refresh requests broadcast by peers,
+// forwarding anything else to next handler.
+func (b *busRefresh) peerHandler(next http.Handler) http.Handler {
+	prefix := "/_groupcache/" + busRefreshGroup + "/"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, found := strings.CutPrefix(r.URL.Path, prefix)
+		if !found || r.Method != http.MethodDelete {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !b.verify(key, r.Header.Get(busRefreshSignatureHeader)) {
+			log.Printf("busrefresh: rejected unsigned peer request from %s", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		source, destination, found := strings.Cut(key, "/")
+		if !found {
+			// peer running older version
+			source, destination = "http", key
+		}
+		b.inv.refreshAndFetch(r.Context(), source, destination)
+	})
+}
+
+// sign computes the signature of broadcast key.
+func (b *busRefresh) sign(key string) string {
+	mac := hmac.New(sha256.New, []byte(b.peerSecret))
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature of broadcast key.
+func (b *busRefresh) verify(key, signature string) bool {
+	if b.peerSecret == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(b.sign(key)))
+}
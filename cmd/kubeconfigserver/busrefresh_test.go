@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBusRefreshAuthorized(t *testing.T) {
+	b := &busRefresh{token: "s3cr3t"}
+	for _, data := range []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{"s3cr3t", false},
+		{"Bearer wrong", false},
+		{"Bearer s3cr3t", true},
+	} {
+		if result := b.authorized(data.header); result != data.expected {
+			t.Errorf("header='%s' expected=%t got=%t", data.header, data.expected, result)
+		}
+	}
+}
+
+func TestBusRefreshPeerHandler(t *testing.T) {
+	tableKeys := newTable()
+	tableKeys.add("/other-default.yml")
+	b := &busRefresh{inv: &invalidator{keys: tableKeys}, peerSecret: "s3cr3t"}
+	unsigned := &busRefresh{inv: &invalidator{keys: tableKeys}}
+
+	var forwarded bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = true
+	})
+	h := b.peerHandler(next)
+
+	for _, data := range []struct {
+		method    string
+		path      string
+		signature string
+		forwarded bool
+		status    int
+	}{
+		{http.MethodDelete, "/_groupcache/_busrefresh/myapp%3A%2A%2A", b.sign("myapp:**"), false, http.StatusOK},
+		{http.MethodDelete, "/_groupcache/_busrefresh/monitor/myapp%3A%2A%2A", b.sign("monitor/myapp:**"), false, http.StatusOK},
+		{http.MethodDelete, "/_groupcache/_busrefresh/monitor%2Fmyapp%3A%2A%2A", b.sign("monitor/myapp:**"), false, http.StatusOK},
+		{http.MethodDelete, "/_groupcache/_busrefresh/monitor/myapp%3A%2A%2A", "", false, http.StatusUnauthorized},
+		{http.MethodDelete, "/_groupcache/_busrefresh/monitor/myapp%3A%2A%2A", b.sign("monitor/other:**"), false, http.StatusUnauthorized},
+		{http.MethodDelete, "/_groupcache/_busrefresh/monitor/myapp%3A%2A%2A", unsigned.sign("monitor/myapp:**"), false, http.StatusUnauthorized},
+		{http.MethodGet, "/_groupcache/_busrefresh/myapp%3A%2A%2A", "", true, http.StatusOK},
+		{http.MethodDelete, "/_groupcache/configfiles/myapp-default.yml", "", true, http.StatusOK},
+		{http.MethodGet, "/_groupcache/configfiles/myapp-default.yml", "", true, http.StatusOK},
+	} {
+		forwarded = false
+		req := httptest.NewRequest(data.method, data.path, nil)
+		if data.signature != "" {
+			req.Header.Set(busRefreshSignatureHeader, data.signature)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if forwarded != data.forwarded {
+			t.Errorf("method=%s path=%s expected forwarded=%t got=%t",
+				data.method, data.path, data.forwarded, forwarded)
+		}
+		if w.Code != data.status {
+			t.Errorf("method=%s path=%s expected status=%d got=%d",
+				data.method, data.path, data.status, w.Code)
+		}
+	}
+
+	// without peer secret, every peer request is rejected
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/_groupcache/_busrefresh/myapp%3A%2A%2A", nil)
+	req.Header.Set(busRefreshSignatureHeader, unsigned.sign("myapp:**"))
+	unsigned.peerHandler(next).ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("no peer secret: expected status=%d got=%d", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Cache entries are stored with a single leading byte that tells
+// how the remaining payload is encoded.
+const (
+	entryRaw  byte = 0
+	entryGzip byte = 1
+)
+
+// encodeEntry prepares data for storage in groupcache.
+// Data with at least minSize bytes is gzip-compressed, unless
+// compression does not reduce its size. minSize < 1 disables compression.
+func encodeEntry(data []byte, minSize int) []byte {
+	if minSize > 0 && len(data) >= minSize {
+		var buf bytes.Buffer
+		buf.WriteByte(entryGzip)
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		_, errWrite := zw.Write(data)
+		errClose := zw.Close()
+		if errWrite == nil && errClose == nil && buf.Len() < len(data)+1 {
+			return buf.Bytes()
+		}
+	}
+	entry := make([]byte, 0, len(data)+1)
+	entry = append(entry, entryRaw)
+	return append(entry, data...)
+}
+
+// decodeEntry splits groupcache entry into encoding and payload.
+func decodeEntry(entry []byte) (byte, []byte, error) {
+	if len(entry) < 1 {
+		return entryRaw, nil, errors.New("decodeEntry: empty cache entry")
+	}
+	encoding := entry[0]
+	switch encoding {
+	case entryRaw, entryGzip:
+	default:
+		return encoding, nil, errors.New("decodeEntry: unknown cache entry encoding: " + strconv.Itoa(int(encoding)))
+	}
+	return encoding, entry[1:], nil
+}
+
+// decompress returns the original data for an entry payload.
+func decompress(encoding byte, payload []byte) ([]byte, error) {
+	if encoding == entryRaw {
+		return payload, nil
+	}
+	zr, errReader := gzip.NewReader(bytes.NewReader(payload))
+	if errReader != nil {
+		return nil, errReader
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// sniff returns up to 512 bytes of original data, enough for content type detection.
+func sniff(encoding byte, payload []byte) []byte {
+	const size = 512
+	if encoding == entryRaw {
+		if len(payload) > size {
+			return payload[:size]
+		}
+		return payload
+	}
+	zr, errReader := gzip.NewReader(bytes.NewReader(payload))
+	if errReader != nil {
+		return nil
+	}
+	defer zr.Close()
+	buf := make([]byte, size)
+	n, _ := io.ReadFull(zr, buf)
+	return buf[:n]
+}
+
+// acceptsGzip checks if Accept-Encoding header value allows gzip.
+// Example: "gzip, deflate, br" or "br;q=1.0, gzip;q=0.8, *;q=0.1"
+func acceptsGzip(acceptEncoding string) bool {
+	for _, enc := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestEntryRoundTrip(t *testing.T) {
+	small := []byte("key: value\n")
+	large := bytes.Repeat([]byte("some.property.name=some value\n"), 1000)
+
+	for _, data := range []struct {
+		name             string
+		input            []byte
+		minSize          int
+		expectedEncoding byte
+	}{
+		{"small", small, 4096, entryRaw},
+		{"large", large, 4096, entryGzip},
+		{"disabled", large, 0, entryRaw},
+	} {
+		entry := encodeEntry(data.input, data.minSize)
+		encoding, payload, err := decodeEntry(entry)
+		if err != nil {
+			t.Errorf("%s: decode error: %v", data.name, err)
+			continue
+		}
+		if encoding != data.expectedEncoding {
+			t.Errorf("%s: expected encoding=%d got=%d", data.name, data.expectedEncoding, encoding)
+		}
+		result, errDecompress := decompress(encoding, payload)
+		if errDecompress != nil {
+			t.Errorf("%s: decompress error: %v", data.name, errDecompress)
+			continue
+		}
+		if !bytes.Equal(result, data.input) {
+			t.Errorf("%s: data mismatch", data.name)
+		}
+		if !bytes.Equal(sniff(encoding, payload), data.input[:min(512, len(data.input))]) {
+			t.Errorf("%s: sniff mismatch", data.name)
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, data := range []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"gzip, deflate, br", true},
+		{"br;q=1.0, gzip;q=0.8", true},
+		{"br, gzip;q=0", false},
+		{"identity", false},
+		{"*", true},
+	} {
+		if result := acceptsGzip(data.header); result != data.expected {
+			t.Errorf("header='%s' expected=%t got=%t", data.header, data.expected, result)
+		}
+	}
+}
+
+func TestSendEncodingHeaders(t *testing.T) {
+	large := bytes.Repeat([]byte("some.property.name=some value\n"), 1000)
+	s := &configServer{}
+	span := trace.SpanFromContext(context.Background())
+
+	etags := map[string]bool{}
+
+	for _, data := range []struct {
+		name           string
+		input          []byte
+		acceptEncoding string
+		encoding       string
+		vary           bool
+	}{
+		{"gzip", large, "gzip", "gzip", true},
+		{"identity", large, "", "", true},
+		{"small", []byte("key: value\n"), "gzip", "", false},
+	} {
+		encoding, payload, _ := decodeEntry(encodeEntry(data.input, 4096))
+		entry := configEntry{encoding: encoding, payload: payload}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/app.properties", nil)
+		c.Request.Header.Set("Accept-Encoding", data.acceptEncoding)
+
+		s.send(c, span, "/app.properties", entry)
+
+		if got := w.Header().Get("Content-Encoding"); got != data.encoding {
+			t.Errorf("%s: expected Content-Encoding=%q got=%q", data.name, data.encoding, got)
+		}
+		if got := w.Header().Get("Vary") == "Accept-Encoding"; got != data.vary {
+			t.Errorf("%s: expected Vary=%t got=%t", data.name, data.vary, got)
+		}
+		etag := w.Header().Get("ETag")
+		if etag == "" || etags[etag] {
+			t.Errorf("%s: expected distinct ETag, got %q", data.name, etag)
+		}
+		etags[etag] = true
+	}
+}
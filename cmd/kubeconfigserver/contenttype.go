@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Content-Type is derived from the file extension, since http.DetectContentType
+reports YAML and properties as text/plain.
+
+CONTENT_TYPES overrides or extends the table, like: .yml=application/x-yaml,.conf=text/plain
+
+Paths without a known extension, like the Spring environment /app/profile,
+are reported as application/json when content looks like JSON,
+or else by http.DetectContentType.
+*/
+
+var defaultContentTypes = map[string]string{
+	".yml":        "application/yaml",
+	".yaml":       "application/yaml",
+	".json":       "application/json",
+	".properties": "text/x-java-properties",
+}
+
+// parseContentTypes parses comma-separated list of ext=type overrides.
+func parseContentTypes(s string) (map[string]string, error) {
+	table := map[string]string{}
+	for _, item := range splitList(s) {
+		ext, ct, found := strings.Cut(item, "=")
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		ct = strings.TrimSpace(ct)
+		if !found || ext == "" || ct == "" {
+			return nil, fmt.Errorf("bad content type '%s', expecting ext=type", item)
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		table[ext] = ct
+	}
+	return table, nil
+}
+
+// contentType finds content type for path, using overrides before default table.
+// sample is the beginning of the content, used when extension is unknown.
+func contentType(overrides map[string]string, path string, sample []byte) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ct, found := overrides[ext]; found {
+		return ct
+	}
+	if ct, found := defaultContentTypes[ext]; found {
+		return ct
+	}
+	trimmed := bytes.TrimSpace(sample)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return "application/json"
+	}
+	return http.DetectContentType(sample)
+}
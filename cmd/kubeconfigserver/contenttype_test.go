@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestContentType(t *testing.T) {
+	overrides, errParse := parseContentTypes(".conf=text/plain, YML=application/x-yaml")
+	if errParse != nil {
+		t.Fatalf("parse: %v", errParse)
+	}
+
+	for _, data := range []struct {
+		path     string
+		sample   string
+		expected string
+	}{
+		{"/app-dev.yaml", "a: 1\n", "application/yaml"},
+		{"/app-dev.yml", "a: 1\n", "application/x-yaml"},
+		{"/app-dev.json", "{}", "application/json"},
+		{"/app-dev.properties", "a=1\n", "text/x-java-properties"},
+		{"/nginx.conf", "{", "text/plain"},
+		{"/app/dev", ` {"name":"app"}`, "application/json"},
+		{"/app/dev", "a: 1\n", "text/plain; charset=utf-8"},
+		{"/logo.png", "\x89PNG\r\n\x1a\n", "image/png"},
+	} {
+		result := contentType(overrides, data.path, []byte(data.sample))
+		if result != data.expected {
+			t.Errorf("path=%s: expected=%s got=%s", data.path, data.expected, result)
+		}
+	}
+
+	for _, bad := range []string{"yml", ".yml=", "=text/plain"} {
+		if _, err := parseContentTypes(bad); err == nil {
+			t.Errorf("input=%s: expected error", bad)
+		}
+	}
+}
+
+func TestHeadOptions(t *testing.T) {
+	dir := t.TempDir()
+	content := "server:\n  port: 8080\n"
+	if err := os.WriteFile(filepath.Join(dir, "app-dev.yml"), []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	tracer := trace.NewNoopTracerProvider().Tracer("test")
+	groups := &cacheGroups{}
+	groups.add("contenttype-test", 1<<20, "", newBackendDir(tracer, dir, "", 0))
+
+	s := &configServer{
+		tracer: tracer,
+		groups: groups,
+		keys:   newTable(),
+		cache:  true,
+	}
+
+	router := gin.New()
+	router.GET("/*anything", s.handle)
+	router.HEAD("/*anything", s.handle)
+	router.OPTIONS("/*anything", handleOptions)
+
+	get := httptest.NewRecorder()
+	router.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/app-dev.yml", nil))
+	if get.Code != http.StatusOK || get.Body.String() != content {
+		t.Fatalf("GET: status=%d body=%q", get.Code, get.Body.String())
+	}
+
+	head := httptest.NewRecorder()
+	router.ServeHTTP(head, httptest.NewRequest(http.MethodHead, "/app-dev.yml", nil))
+	if head.Code != http.StatusOK {
+		t.Errorf("HEAD: status=%d", head.Code)
+	}
+	if head.Body.Len() != 0 {
+		t.Errorf("HEAD: unexpected body: %q", head.Body.String())
+	}
+	for _, h := range []string{"Content-Type", "Content-Length", "ETag"} {
+		if head.Header().Get(h) != get.Header().Get(h) {
+			t.Errorf("HEAD: header %s: expected=%q got=%q", h, get.Header().Get(h), head.Header().Get(h))
+		}
+	}
+	if ct := head.Header().Get("Content-Type"); ct != "application/yaml" {
+		t.Errorf("HEAD: content type: %s", ct)
+	}
+	if cl := head.Header().Get("Content-Length"); cl != strconv.Itoa(len(content)) {
+		t.Errorf("HEAD: content length: %s", cl)
+	}
+
+	options := httptest.NewRecorder()
+	router.ServeHTTP(options, httptest.NewRequest(http.MethodOptions, "/app-dev.yml", nil))
+	if options.Code != http.StatusNoContent || options.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("OPTIONS: status=%d allow=%q", options.Code, options.Header().Get("Allow"))
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailgun/groupcache"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultGroupName = "configfiles"
+
+// groupSpec describes one groupcache group.
+// Example spec: "bundles:256MiB:/bundles/:http://bundles-server:9000"
+type groupSpec struct {
+	name    string
+	size    int64
+	prefix  string // select group for keys with this prefix
+	backend string // optional, empty means default backend
+}
+
+// parseGroups parses comma-separated list of group specs.
+// Each spec is name:size:prefix[:backend]
+// Names and prefixes must be unique, and prefixes must not be empty,
+// since the default group takes keys matching no prefix.
+func parseGroups(s string) ([]groupSpec, error) {
+	var specs []groupSpec
+	names := map[string]bool{}
+	prefixes := map[string]bool{}
+	for _, str := range strings.Split(s, ",") {
+		str = strings.TrimSpace(str)
+		if str == "" {
+			continue
+		}
+		fields := strings.SplitN(str, ":", 4)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("bad group spec '%s': expecting name:size:prefix[:backend]", str)
+		}
+		name := fields[0]
+		if name == "" {
+			return nil, fmt.Errorf("bad group spec '%s': empty name", str)
+		}
+		if name == defaultGroupName {
+			return nil, fmt.Errorf("bad group spec '%s': name '%s' is reserved", str, defaultGroupName)
+		}
+		size, errSize := parseSize(fields[1])
+		if errSize != nil {
+			return nil, fmt.Errorf("bad group spec '%s': %v", str, errSize)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("bad group spec '%s': duplicate name '%s'", str, name)
+		}
+		prefix := fields[2]
+		if prefix == "" {
+			return nil, fmt.Errorf("bad group spec '%s': empty prefix", str)
+		}
+		if prefixes[prefix] {
+			return nil, fmt.Errorf("bad group spec '%s': duplicate prefix '%s'", str, prefix)
+		}
+		names[name] = true
+		prefixes[prefix] = true
+		spec := groupSpec{
+			name:   name,
+			size:   size,
+			prefix: prefix,
+		}
+		if len(fields) > 3 {
+			spec.backend = fields[3]
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// parseSize parses memory size like "1000", "64KiB", "64MiB", "1GiB".
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KiB", 1 << 10},
+		{"MiB", 1 << 20},
+		{"GiB", 1 << 30},
+	} {
+		if str := strings.TrimSuffix(s, u.suffix); str != s {
+			s = str
+			multiplier = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad size: %v", err)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("bad size: %d", n)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("bad size: %d*%d overflows", n, multiplier)
+	}
+	return n * multiplier, nil
+}
+
+type cacheGroup struct {
+	group  *groupcache.Group
+	prefix string
+
+	mutex     sync.Mutex
+	evictions map[groupcache.CacheType]int64 // last seen evictions
+}
+
+// reportEvictions logs evictions that happened since last report.
+func (g *cacheGroup) reportEvictions() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, c := range []struct {
+		name  string
+		cache groupcache.CacheType
+	}{
+		{"main", groupcache.MainCache},
+		{"hot", groupcache.HotCache},
+	} {
+		stats := g.group.CacheStats(c.cache)
+		last := g.evictions[c.cache]
+		if stats.Evictions > last {
+			log.Printf("groupcache: group=%s cache=%s evictions=%d (+%d) bytes=%d items=%d",
+				g.group.Name(), c.name, stats.Evictions, stats.Evictions-last,
+				stats.Bytes, stats.Items)
+		}
+		g.evictions[c.cache] = stats.Evictions
+	}
+}
+
+// cacheGroups holds the default group plus optional groups selected by key prefix.
+type cacheGroups struct {
+	groups          []*cacheGroup // default group is the first one
+	ttl             atomic.Int64  // time.Duration for new entries
+	compressMinSize int
+
+	// onLoad is optionally called whenever a key is loaded from backend
+	onLoad func(key string, storage backend, data []byte)
+}
+
+func newCacheGroups(tracer trace.Tracer, config appConfig, defaultStorage backend) *cacheGroups {
+	specs, errSpecs := parseGroups(config.groupcacheGroups)
+	if errSpecs != nil {
+		log.Fatalf("groupcache groups: %v", errSpecs)
+	}
+
+	cg := &cacheGroups{
+		compressMinSize: config.compressMinSize,
+	}
+	cg.setTTL(config.ttl)
+
+	cg.add(defaultGroupName, config.groupcacheSize, "", defaultStorage)
+
+	for _, spec := range specs {
+		storage := defaultStorage
+		if spec.backend != "" {
+			storage = newBackend(tracer, spec.backend, config.backendOptions, config.maxObjectSize)
+		}
+		cg.add(spec.name, spec.size, spec.prefix, storage)
+	}
+
+	return cg
+}
+
+func (cg *cacheGroups) add(name string, size int64, prefix string, storage backend) {
+	log.Printf("groupcache: group=%s size=%d prefix='%s'", name, size, prefix)
+
+	g := groupcache.NewGroup(name, size, groupcache.GetterFunc(
+		func(ctx groupcache.Context, filename string, dest groupcache.Sink) error {
+			var newCtx context.Context
+			if ctx == nil {
+				newCtx = context.Background()
+			} else {
+				newCtx = ctx.(context.Context)
+			}
+
+			data, errFetch := fetch(newCtx, storage, filename)
+			if errFetch != nil {
+				return errFetch
+			}
+			if cg.onLoad != nil {
+				cg.onLoad(filename, storage, data)
+			}
+			var expire time.Time // zero value for expire means no expiration
+			if ttl := cg.getTTL(); ttl != 0 {
+				expire = time.Now().Add(ttl)
+			}
+			return dest.SetBytes(encodeEntry(data, cg.compressMinSize), expire)
+		}))
+
+	cg.groups = append(cg.groups, &cacheGroup{
+		group:     g,
+		prefix:    prefix,
+		evictions: map[groupcache.CacheType]int64{},
+	})
+}
+
+// setTTL defines expiration for new cache entries. Zero means no expiration.
+func (cg *cacheGroups) setTTL(ttl time.Duration) {
+	cg.ttl.Store(int64(ttl))
+}
+
+func (cg *cacheGroups) getTTL() time.Duration {
+	return time.Duration(cg.ttl.Load())
+}
+
+// pick finds the group with longest prefix matching the key.
+func (cg *cacheGroups) pick(key string) *cacheGroup {
+	found := cg.groups[0]
+	for _, g := range cg.groups[1:] {
+		if strings.HasPrefix(key, g.prefix) && len(g.prefix) > len(found.prefix) {
+			found = g
+		}
+	}
+	return found
+}
+
+func (cg *cacheGroups) remove(ctx context.Context, key string) error {
+	return cg.pick(key).group.Remove(ctx, key)
+}
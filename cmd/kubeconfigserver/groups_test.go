@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+)
+
+type testSize struct {
+	input        string
+	expectedSize int64
+	expectError  bool
+}
+
+var testSizeTable = []testSize{
+	{"1000", 1000, false},
+	{"64KiB", 64 << 10, false},
+	{"64MiB", 64 << 20, false},
+	{"2GiB", 2 << 30, false},
+	{"", 0, true},
+	{"0", 0, true},
+	{"-1MiB", 0, true},
+	{"64MB", 0, true},
+	{"0MiB", 0, true},
+	{"9223372036854775807", 9223372036854775807, false},
+	{"8589934592GiB", 0, true}, // 2^33 GiB overflows
+	{"8589934591GiB", 8589934591 << 30, false},
+}
+
+func TestParseSize(t *testing.T) {
+	for _, data := range testSizeTable {
+		size, err := parseSize(data.input)
+		if (err != nil) != data.expectError {
+			t.Errorf("input='%s' expectError=%t got error: %v", data.input, data.expectError, err)
+			continue
+		}
+		if size != data.expectedSize {
+			t.Errorf("input='%s' expected=%d got=%d", data.input, data.expectedSize, size)
+		}
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+	specs, err := parseGroups("bundles:256MiB:/bundles/:http://bundles:9000, small:1000:/small/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []groupSpec{
+		{name: "bundles", size: 256 << 20, prefix: "/bundles/", backend: "http://bundles:9000"},
+		{name: "small", size: 1000, prefix: "/small/"},
+	}
+	if len(specs) != len(expected) {
+		t.Fatalf("expected %d specs, got %d: %v", len(expected), len(specs), specs)
+	}
+	for i, s := range specs {
+		if s != expected[i] {
+			t.Errorf("spec %d: expected=%v got=%v", i, expected[i], s)
+		}
+	}
+
+	for _, bad := range []string{
+		"bundles", "bundles:1MiB", ":1MiB:/x/", "configfiles:1MiB:/x/", "x:bad:/x/",
+		"x:0:/x/",                // zero size
+		"x:1MiB:",                // empty prefix
+		"x:1MiB:/x/,y:1MiB:/x/",  // duplicate prefix
+		"x:1MiB:/x/,x:1MiB:/y/",  // duplicate name
+		"x:9999999999999GiB:/x/", // overflow
+	} {
+		if _, errBad := parseGroups(bad); errBad == nil {
+			t.Errorf("spec='%s' expected error", bad)
+		}
+	}
+}
+
+func TestPickGroup(t *testing.T) {
+	cg := &cacheGroups{groups: []*cacheGroup{
+		{prefix: ""},
+		{prefix: "/bundles/"},
+		{prefix: "/bundles/large/"},
+	}}
+	for _, data := range []struct {
+		key    string
+		prefix string
+	}{
+		{"/app-default.yml", ""},
+		{"/bundles/app.zip", "/bundles/"},
+		{"/bundles/large/app.zip", "/bundles/large/"},
+	} {
+		if g := cg.pick(data.key); g.prefix != data.prefix {
+			t.Errorf("key='%s' expected prefix='%s' got='%s'", data.key, data.prefix, g.prefix)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/udhos/kubecloudconfigserver/configpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+gRPC API, defined in configpb/config.proto.
+
+Config files are served from the same groupcache groups as the HTTP API,
+and Watch is woken up by the same refresh machinery as long-poll and SSE.
+*/
+
+type grpcServer struct {
+	configpb.UnimplementedConfigServerServer
+	configs *configServer
+	auth    *auth       // optional
+	limits  *rateLimits // optional
+}
+
+// authorize authenticates the call, checks if the principal may read paths,
+// then applies the per-identity rate limit.
+func (g *grpcServer) authorize(ctx context.Context, paths ...string) (*principal, error) {
+	p, errAuth := g.auth.authorizeGrpc(ctx, paths...)
+	if errAuth != nil {
+		return nil, errAuth
+	}
+	if err := g.limits.perClientGrpc(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (g *grpcServer) Get(ctx context.Context, req *configpb.GetRequest) (*configpb.ConfigFile, error) {
+	path := fixPath(req.GetPath())
+
+	p, errAuth := g.authorize(ctx, path)
+	if errAuth != nil {
+		return nil, errAuth
+	}
+
+	newCtx, span := g.configs.tracer.Start(ctx, "grpc.Get")
+	defer span.End()
+
+	entry, errGet := g.configs.read(newCtx, path, p)
+	if errGet != nil {
+		return nil, grpcError(errGet)
+	}
+
+	body, errDecompress := decompress(entry.encoding, entry.payload)
+	if errDecompress != nil {
+		log.Printf("grpc: path='%s': decompress: %v", path, errDecompress)
+		return nil, grpcError(errDecompress)
+	}
+
+	return &configpb.ConfigFile{Path: path, Version: entry.version(), Content: body}, nil
+}
+
+// springEnvironment is the Spring Cloud Config Server environment JSON.
+type springEnvironment struct {
+	Name            string   `json:"name"`
+	Profiles        []string `json:"profiles"`
+	Label           string   `json:"label"`
+	Version         string   `json:"version"`
+	State           string   `json:"state"`
+	PropertySources []struct {
+		Name   string                     `json:"name"`
+		Source map[string]json.RawMessage `json:"source"`
+	} `json:"propertySources"`
+}
+
+func (g *grpcServer) GetEnvironment(ctx context.Context, req *configpb.EnvironmentRequest) (*configpb.Environment, error) {
+	if req.GetApplication() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing application")
+	}
+
+	path := environmentPath(req.GetApplication(), req.GetProfiles(), req.GetLabel())
+
+	p, errAuth := g.authorize(ctx, path)
+	if errAuth != nil {
+		return nil, errAuth
+	}
+
+	newCtx, span := g.configs.tracer.Start(ctx, "grpc.GetEnvironment")
+	defer span.End()
+
+	entry, errGet := g.configs.read(newCtx, path, p)
+	if errGet != nil {
+		return nil, grpcError(errGet)
+	}
+
+	body, errDecompress := decompress(entry.encoding, entry.payload)
+	if errDecompress != nil {
+		log.Printf("grpc: path='%s': decompress: %v", path, errDecompress)
+		return nil, grpcError(errDecompress)
+	}
+
+	return parseEnvironment(body)
+}
+
+// environmentPath builds Spring Cloud Config Server path /application/profiles[/label]
+func environmentPath(application string, profiles []string, label string) string {
+	if len(profiles) == 0 {
+		profiles = []string{"default"}
+	}
+	path := "/" + application + "/" + strings.Join(profiles, ",")
+	if label != "" {
+		path += "/" + strings.ReplaceAll(label, "/", "(_)")
+	}
+	return path
+}
+
+func parseEnvironment(body []byte) (*configpb.Environment, error) {
+	var env springEnvironment
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "not a spring environment: %v", err)
+	}
+
+	result := &configpb.Environment{
+		Name:     env.Name,
+		Profiles: env.Profiles,
+		Label:    env.Label,
+		Version:  env.Version,
+		State:    env.State,
+	}
+
+	for _, ps := range env.PropertySources {
+		source := map[string]string{}
+		for k, v := range ps.Source {
+			var str string
+			if json.Unmarshal(v, &str) == nil {
+				source[k] = str
+				continue
+			}
+			source[k] = string(v) // non-string value, keep JSON encoding
+		}
+		result.PropertySources = append(result.PropertySources,
+			&configpb.PropertySource{Name: ps.Name, Source: source})
+	}
+
+	return result, nil
+}
+
+func (g *grpcServer) Watch(req *configpb.WatchRequest, stream configpb.ConfigServer_WatchServer) error {
+	if len(req.GetPaths()) == 0 {
+		return status.Error(codes.InvalidArgument, "missing paths")
+	}
+
+	var paths []string
+	for _, p := range req.GetPaths() {
+		paths = append(paths, fixPath(p))
+	}
+
+	p, errAuth := g.authorize(stream.Context(), paths...)
+	if errAuth != nil {
+		return errAuth
+	}
+
+	err := g.configs.watchPaths(stream.Context(), paths, p, func(event watchEvent) error {
+		return stream.Send(&configpb.ConfigFile{
+			Path:    event.Path,
+			Version: event.Version,
+			Content: []byte(event.Content),
+			Error:   event.Error,
+		})
+	})
+
+	log.Printf("grpc: watch paths=%v: %v", paths, err)
+
+	if errCtx := stream.Context().Err(); errCtx != nil {
+		return status.FromContextError(errCtx).Err()
+	}
+
+	if err == errShuttingDown {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	return err
+}
+
+// fixPath adds leading slash to path, like in HTTP request paths.
+func fixPath(path string) string {
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+	return "/" + path
+}
+
+// grpcError converts error from configServer.get into gRPC status.
+func grpcError(err error) error {
+	if errBackend, isBackend := err.(backendError); isBackend {
+		switch errBackend.status {
+		case http.StatusNotFound:
+			return status.Error(codes.NotFound, "not found")
+		case statusTooLarge:
+			return status.Error(codes.Internal, "backend object exceeds MAX_OBJECT_SIZE")
+		}
+	}
+	return status.Error(codes.Internal, "server error")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/udhos/kubecloudconfigserver/configpb"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestEnvironmentPath(t *testing.T) {
+	for _, data := range []struct {
+		application string
+		profiles    []string
+		label       string
+		expected    string
+	}{
+		{"app", nil, "", "/app/default"},
+		{"app", []string{"dev"}, "", "/app/dev"},
+		{"app", []string{"dev", "db"}, "main", "/app/dev,db/main"},
+		{"app", []string{"dev"}, "feature/x", "/app/dev/feature(_)x"},
+	} {
+		result := environmentPath(data.application, data.profiles, data.label)
+		if result != data.expected {
+			t.Errorf("app=%s profiles=%v label=%s expected=%s got=%s",
+				data.application, data.profiles, data.label, data.expected, result)
+		}
+	}
+}
+
+func TestGrpc(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app-default.yml"), []byte("a: 1\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "app"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	env := `{"name":"app","profiles":["default"],"propertySources":[{"name":"app.yml","source":{"a":"x","b":2}}]}`
+	if err := os.WriteFile(filepath.Join(dir, "app", "default"), []byte(env), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	tracer := trace.NewNoopTracerProvider().Tracer("test")
+	storage := newBackendDir(tracer, dir, "", 0)
+	groups := &cacheGroups{}
+	groups.add("grpc-test", 1<<20, "", storage)
+
+	configs := &configServer{
+		tracer:       tracer,
+		groups:       groups,
+		keys:         newTable(),
+		cache:        true,
+		watch:        newWatchHub(),
+		watchMaxWait: time.Minute,
+		watchRecheck: time.Minute,
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	configpb.RegisterConfigServerServer(server, &grpcServer{configs: configs})
+	go server.Serve(lis)
+	defer server.Stop()
+
+	ctx := context.Background()
+
+	conn, errDial := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if errDial != nil {
+		t.Fatalf("dial: %v", errDial)
+	}
+	defer conn.Close()
+
+	client := configpb.NewConfigServerClient(conn)
+
+	file, errGet := client.Get(ctx, &configpb.GetRequest{Path: "app-default.yml"})
+	if errGet != nil {
+		t.Fatalf("get: %v", errGet)
+	}
+	if string(file.Content) != "a: 1\n" || file.Version == "" {
+		t.Errorf("get: content=%q version=%q", file.Content, file.Version)
+	}
+
+	_, errMissing := client.Get(ctx, &configpb.GetRequest{Path: "/missing.yml"})
+	if status.Code(errMissing) != codes.NotFound {
+		t.Errorf("get missing: expected NotFound, got: %v", errMissing)
+	}
+
+	environment, errEnv := client.GetEnvironment(ctx, &configpb.EnvironmentRequest{Application: "app"})
+	if errEnv != nil {
+		t.Fatalf("get environment: %v", errEnv)
+	}
+	if len(environment.PropertySources) != 1 {
+		t.Fatalf("get environment: expected 1 property source, got %d", len(environment.PropertySources))
+	}
+	if source := environment.PropertySources[0].Source; source["a"] != "x" || source["b"] != "2" {
+		t.Errorf("get environment: unexpected source: %v", source)
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, errWatch := client.Watch(watchCtx, &configpb.WatchRequest{Paths: []string{"/app-default.yml", "/missing.yml"}})
+	if errWatch != nil {
+		t.Fatalf("watch: %v", errWatch)
+	}
+	for i := 0; i < 2; i++ {
+		event, errRecv := stream.Recv()
+		if errRecv != nil {
+			t.Fatalf("watch recv: %v", errRecv)
+		}
+		switch event.Path {
+		case "/app-default.yml":
+			if event.Version != file.Version || event.Error != "" {
+				t.Errorf("watch: unexpected event: %v", event)
+			}
+		case "/missing.yml":
+			if event.Error == "" {
+				t.Errorf("watch: missing error for path %s", event.Path)
+			}
+		default:
+			t.Errorf("watch: unexpected path: %s", event.Path)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/groupcache"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// configServer serves config files from groupcache,
+// or straight from backend when cache is disabled.
+type configServer struct {
+	tracer  trace.Tracer
+	groups  *cacheGroups
+	keys    *table
+	noCache *coalescer // used in place of groupcache when cache is disabled
+	cache   bool
+
+	watch        *watchHub
+	watchMaxWait time.Duration
+	watchRecheck time.Duration
+
+	redact *redactor // optional
+
+	contentTypes map[string]string // overrides defaultContentTypes
+}
+
+// configEntry is config file content as stored in cache.
+type configEntry struct {
+	encoding byte
+	payload  []byte
+}
+
+// get retrieves config file for path.
+func (s *configServer) get(ctx context.Context, path string) (configEntry, error) {
+	if !s.cache {
+		// cache disabled
+		data, errFetch := s.noCache.fetch(ctx, path)
+		if errFetch != nil {
+			return configEntry{}, errFetch
+		}
+		return configEntry{encoding: entryRaw, payload: data}, nil
+	}
+
+	group := s.groups.pick(path)
+
+	var data []byte
+	errGet := group.group.Get(ctx, path, groupcache.AllocatingByteSliceSink(&data))
+	log.Printf("groupcache.Get: group=%s path='%s' error:%v", group.group.Name(), path, errGet)
+	if errGet != nil {
+		return configEntry{}, errGet
+	}
+
+	encoding, payload, errDecode := decodeEntry(data)
+	if errDecode != nil {
+		log.Printf("path='%s': %v", path, errDecode)
+		return configEntry{}, errDecode
+	}
+
+	s.keys.add(path) // record path
+
+	group.reportEvictions()
+
+	return configEntry{encoding: encoding, payload: payload}, nil
+}
+
+// read retrieves config file for path as seen by principal p,
+// with secrets redacted unless p is privileged.
+func (s *configServer) read(ctx context.Context, path string, p *principal) (configEntry, error) {
+	entry, errGet := s.get(ctx, path)
+	if errGet != nil || s.redact == nil || s.redact.isPrivileged(p) {
+		return entry, errGet
+	}
+
+	body, errDecompress := decompress(entry.encoding, entry.payload)
+	if errDecompress != nil {
+		log.Printf("path='%s': decompress: %v", path, errDecompress)
+		return configEntry{}, errDecompress
+	}
+
+	redacted, errRedact := s.redact.redact(path, body)
+	if errRedact != nil {
+		log.Printf("path='%s': %v", path, errRedact)
+		return configEntry{}, errRedact // do not leak secrets
+	}
+
+	return configEntry{encoding: entryRaw, payload: redacted}, nil
+}
+
+// principalOf gets authenticated principal from request context.
+// It returns nil when authentication is disabled.
+func principalOf(c *gin.Context) *principal {
+	if p, found := c.Get(principalKey); found {
+		return p.(*principal)
+	}
+	return nil
+}
+
+// gzipETagSuffix tells gzip-encoded responses apart from identity
+// responses with the same version, since their bodies differ.
+const gzipETagSuffix = "-gzip"
+
+// version identifies config file content, for use as etag.
+func (e configEntry) version() string {
+	sum := sha256.Sum256(e.payload)
+	return hex.EncodeToString(sum[:16])
+}
+
+// handle serves GET and HEAD /*anything
+func (s *configServer) handle(c *gin.Context) {
+
+	path := c.Param("anything")
+
+	ctx := c.Request.Context()
+
+	newCtx, span := s.tracer.Start(ctx, "request")
+	defer span.End()
+
+	log.Printf("traceID=%s", span.SpanContext().TraceID())
+
+	p := principalOf(c)
+
+	if c.Request.Method == http.MethodGet {
+		if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+			s.stream(newCtx, c, p, append([]string{path}, c.QueryArray("path")...))
+			return
+		}
+
+		if wait := c.Query("wait"); wait != "" {
+			s.longPoll(newCtx, c, span, p, path, wait)
+			return
+		}
+	}
+
+	entry, errGet := s.read(newCtx, path, p)
+	if errGet != nil {
+		sendError(c, span, errGet)
+		return
+	}
+
+	s.send(c, span, path, entry)
+}
+
+// send writes config file entry into response.
+func (s *configServer) send(c *gin.Context, span trace.Span, path string, entry configEntry) {
+	if entry.encoding == entryGzip {
+		// response depends on Accept-Encoding, whether compressed or not
+		c.Header("Vary", "Accept-Encoding")
+	}
+
+	if entry.encoding == entryGzip && acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		// send compressed entry as is
+		c.Header("ETag", `"`+entry.version()+gzipETagSuffix+`"`)
+		c.Header("Content-Encoding", "gzip")
+		reply(c, contentType(s.contentTypes, path, sniff(entry.encoding, entry.payload)), entry.payload)
+		return
+	}
+
+	body, errDecompress := decompress(entry.encoding, entry.payload)
+	if errDecompress != nil {
+		log.Printf("path='%s': decompress: %v", path, errDecompress)
+		sendError(c, span, errDecompress)
+		return
+	}
+
+	c.Header("ETag", `"`+entry.version()+`"`)
+	reply(c, contentType(s.contentTypes, path, sniff(entryRaw, body)), body)
+}
+
+// reply sends body with status 200, omitting body for HEAD request.
+func reply(c *gin.Context, contentType string, body []byte) {
+	c.Header("Content-Length", strconv.Itoa(len(body)))
+	if c.Request.Method == http.MethodHead {
+		c.Header("Content-Type", contentType)
+		c.Status(http.StatusOK)
+		return
+	}
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// handleOptions serves OPTIONS /*anything
+func handleOptions(c *gin.Context) {
+	c.Header("Allow", "GET, HEAD, OPTIONS")
+	c.Status(http.StatusNoContent)
+}
+
+func sendError(c *gin.Context, span trace.Span, err error) {
+	span.SetStatus(codes.Error, err.Error())
+	if errBackend, isBackend := err.(backendError); isBackend {
+		sendBackendError(c, errBackend)
+		return
+	}
+	c.String(http.StatusInternalServerError, "server error")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthStatus tracks components running in degraded mode.
+// A degraded server still works, hence health keeps reporting success.
+type healthStatus struct {
+	mutex    sync.Mutex
+	degraded map[string]string // component => reason
+}
+
+func newHealthStatus() *healthStatus {
+	return &healthStatus{degraded: map[string]string{}}
+}
+
+func (h *healthStatus) degrade(component, reason string) {
+	h.mutex.Lock()
+	h.degraded[component] = reason
+	h.mutex.Unlock()
+}
+
+func (h *healthStatus) recover(component string) {
+	h.mutex.Lock()
+	delete(h.degraded, component)
+	h.mutex.Unlock()
+}
+
+// isDegraded reports whether component is degraded, and why.
+func (h *healthStatus) isDegraded(component string) (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	reason, found := h.degraded[component]
+	return reason, found
+}
+
+func (h *healthStatus) String() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.degraded) == 0 {
+		return "health ok"
+	}
+	var list []string
+	for component, reason := range h.degraded {
+		list = append(list, fmt.Sprintf("%s: %s", component, reason))
+	}
+	sort.Strings(list)
+	return "health degraded: " + strings.Join(list, ", ")
+}
+
+func (h *healthStatus) handle(c *gin.Context) {
+	c.String(http.StatusOK, h.String())
+}
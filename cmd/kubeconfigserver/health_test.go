@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHealthStatus(t *testing.T) {
+	h := newHealthStatus()
+
+	if s := h.String(); s != "health ok" {
+		t.Errorf("expected ok, got: %s", s)
+	}
+
+	h.degrade("kafka", "down")
+	h.degrade("amqp", "refresh channel closed")
+
+	if s, expected := h.String(), "health degraded: amqp: refresh channel closed, kafka: down"; s != expected {
+		t.Errorf("expected '%s', got: '%s'", expected, s)
+	}
+
+	h.recover("amqp")
+	h.recover("kafka")
+
+	if s := h.String(); s != "health ok" {
+		t.Errorf("expected ok after recover, got: %s", s)
+	}
+}
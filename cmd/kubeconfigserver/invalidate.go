@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+
+	"github.com/mailgun/groupcache"
+)
+
+// invalidator removes cache keys matching refresh notifications.
+type invalidator struct {
+	groups      *cacheGroups
+	keys        *table
+	audit       *refreshAudit
+	republisher *republisher // optional
+	watch       *watchHub    // optional
+
+	// elect tells if this replica re-publishes events for destination,
+	// since every replica receives the same AMQP/Kafka notification.
+	// nil elects every replica.
+	elect func(destination string) bool
+}
+
+// refresh removes cache keys matching application.
+// source tells where the notification came from, like "amqp" or "monitor".
+// It returns the keys that were removed.
+func (inv *invalidator) refresh(ctx context.Context, source, application string) []string {
+	// application = "config-cli-example:**"
+	log.Printf("refresh: source=%s received notification for application='%s'", source, application)
+	return inv.removeKeys(ctx, source, application, inv.keys.match(application))
+}
+
+// removeKeys removes keys from cache, recording the refresh event into audit log.
+// It returns the keys that were removed.
+func (inv *invalidator) removeKeys(ctx context.Context, source, destination string, keys []string) []string {
+	rec := refreshRecord{
+		Source:      source,
+		Destination: destination,
+		Removed:     []string{},
+	}
+	for _, key := range keys {
+		log.Printf("refresh: source=%s removing key='%s' for application='%s'", source, key, destination)
+		if errRemove := inv.groups.remove(ctx, key); errRemove != nil {
+			log.Printf("refresh: source=%s removing key='%s' for application='%s': error: %v",
+				source, key, destination, errRemove)
+			rec.Errors = append(rec.Errors, key+": "+errRemove.Error())
+			continue
+		}
+		inv.keys.del(key)
+		inv.watch.notify(key)
+		rec.Removed = append(rec.Removed, key)
+	}
+	if inv.audit != nil {
+		inv.audit.record(rec)
+	}
+	return rec.Removed
+}
+
+// refreshAndFetch removes cache keys matching application, then re-fetches them
+// when re-publishing is enabled. It returns the keys that were removed.
+func (inv *invalidator) refreshAndFetch(ctx context.Context, source, application string) []string {
+	removed := inv.refresh(ctx, source, application)
+	inv.refetch(ctx, application, removed)
+	return removed
+}
+
+// refreshAndPublish removes cache keys matching application, then
+// re-fetches them and, on the elected replica, re-publishes the refresh
+// event to downstream clients. It handles notifications received by every
+// replica, like AMQP and Kafka.
+func (inv *invalidator) refreshAndPublish(ctx context.Context, source, application string) []string {
+	removed := inv.refreshAndFetch(ctx, source, application)
+	if inv.elect != nil && !inv.elect(application) {
+		log.Printf("refresh: source=%s application='%s': another replica re-publishes", source, application)
+		return removed
+	}
+	inv.publish(ctx, application, removed)
+	return removed
+}
+
+// refetch loads removed keys back into the cache, when re-publishing is enabled.
+func (inv *invalidator) refetch(ctx context.Context, application string, keys []string) {
+	if !inv.republisher.enabled() {
+		return
+	}
+	for _, key := range keys {
+		var data []byte
+		if err := inv.groups.pick(key).group.Get(ctx, key, groupcache.AllocatingByteSliceSink(&data)); err != nil {
+			log.Printf("refresh: re-fetch key='%s' for application='%s': error: %v", key, application, err)
+			continue
+		}
+		inv.keys.add(key)
+	}
+}
+
+// publish re-publishes the refresh event for keys already re-fetched.
+func (inv *invalidator) publish(ctx context.Context, application string, keys []string) {
+	if !inv.republisher.enabled() {
+		return
+	}
+	inv.republisher.republish(ctx, application, keys)
+}
+
+// keyApplications finds refresh destinations for applications referenced by key,
+// like "/myapp-dev.yml" -> "myapp:**".
+func keyApplications(key string) []string {
+	var destinations []string
+	for _, id := range parseKey(key) {
+		if d := id[0] + ":**"; !slices.Contains(destinations, d) {
+			destinations = append(destinations, d)
+		}
+	}
+	return destinations
+}
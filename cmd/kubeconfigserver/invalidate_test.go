@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+)
+
+type recordPublisher struct {
+	mutex  sync.Mutex
+	events []changeEvent
+}
+
+func (p *recordPublisher) String() string {
+	return "record"
+}
+
+func (p *recordPublisher) publish(_ context.Context, body []byte) error {
+	var event changeEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	p.events = append(p.events, event)
+	p.mutex.Unlock()
+	return nil
+}
+
+func TestRefreshAndPublishElect(t *testing.T) {
+	events := &recordPublisher{}
+	inv := &invalidator{
+		keys:        newTable(),
+		republisher: &republisher{origin: "test", publishers: []publisher{events}},
+		elect:       func(destination string) bool { return destination == "mine:**" },
+	}
+
+	inv.refreshAndPublish(context.Background(), "amqp", "mine:**")
+	inv.refreshAndPublish(context.Background(), "amqp", "other:**")
+
+	if len(events.events) != 1 || events.events[0].DestinationService != "mine:**" {
+		t.Errorf("expected only elected destination re-published, got: %+v", events.events)
+	}
+}
+
+func TestKeyApplications(t *testing.T) {
+	for _, data := range []struct {
+		key      string
+		expected []string
+	}{
+		{"/app-dev.yml", []string{"app:**"}},
+		{"/app/dev,prod/main", []string{"app:**"}},
+		{"/path/a.yml,b.yml", []string{"a:**", "b:**"}},
+		{"/", nil},
+	} {
+		if result := keyApplications(data.key); !slices.Equal(result, data.expected) {
+			t.Errorf("key=%s expected=%v got=%v", data.key, data.expected, result)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+/*
+JWT/OIDC bearer tokens verified against a local JWKS file (AUTH_JWKS_FILE),
+for instance the keys of an OIDC provider synced into a ConfigMap.
+
+Supported algorithms: RS256 and ES256. Claim exp is required, nbf is checked when present.
+Claim aud must contain AUTH_JWT_AUDIENCE, which is required, otherwise tokens issued
+by the same provider for any other service would be accepted.
+AUTH_JWT_ISSUER, when defined, must match claim iss.
+The principal identity is "jwt:<sub>", plus "jwt:group:<name>" for groups from claim AUTH_JWT_GROUPS_CLAIM.
+
+Whenever a token refers to an unknown key id, the JWKS file is reloaded if modified.
+
+Signature verification, JWKS parsing and standard claim checks are done by go-jose.
+*/
+
+// jwtClockSkew tolerates clock differences when checking exp and nbf.
+const jwtClockSkew = time.Minute
+
+type jwtAuth struct {
+	file        string
+	issuer      string
+	audience    string
+	groupsClaim string
+
+	mutex     sync.Mutex
+	keys      map[string]jose.JSONWebKey // kid => key
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newJwtAuth(file, issuer, audience, groupsClaim string) (*jwtAuth, error) {
+	if audience == "" {
+		return nil, errors.New("AUTH_JWT_AUDIENCE is required")
+	}
+	j := &jwtAuth{
+		file:        file,
+		issuer:      issuer,
+		audience:    audience,
+		groupsClaim: groupsClaim,
+	}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// jwtAlgorithms lists accepted signature algorithms.
+var jwtAlgorithms = []jose.SignatureAlgorithm{jose.RS256, jose.ES256}
+
+// load must be called with mutex held, except from constructor.
+func (j *jwtAuth) load() error {
+	info, errStat := os.Stat(j.file)
+	if errStat != nil {
+		return errStat
+	}
+	data, errRead := os.ReadFile(j.file)
+	if errRead != nil {
+		return errRead
+	}
+	keys, errParse := parseJWKS(data)
+	if errParse != nil {
+		return errParse
+	}
+	j.keys = keys
+	j.modTime = info.ModTime()
+	j.lastCheck = time.Now()
+	log.Printf("auth: jwt: loaded %d keys from %s", len(keys), j.file)
+	return nil
+}
+
+// parseJWKS parses RSA and P-256 public keys, skipping any other key.
+func parseJWKS(data []byte) (map[string]jose.JSONWebKey, error) {
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]jose.JSONWebKey{}
+	for _, raw := range set.Keys {
+		var k jose.JSONWebKey
+		if err := k.UnmarshalJSON(raw); err != nil {
+			log.Printf("auth: jwt: skipping key: %v", err)
+			continue
+		}
+		if err := checkKey(k); err != nil {
+			log.Printf("auth: jwt: skipping key kid=%s: %v", k.KeyID, err)
+			continue
+		}
+		keys[k.KeyID] = k
+	}
+	return keys, nil
+}
+
+func checkKey(k jose.JSONWebKey) error {
+	switch key := k.Key.(type) {
+	case *rsa.PublicKey:
+		return nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return fmt.Errorf("unsupported curve: %s", key.Curve.Params().Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type: %T", k.Key)
+}
+
+// key finds key by id, reloading JWKS file if modified.
+func (j *jwtAuth) key(kid string) (jose.JSONWebKey, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if key, found := j.keys[kid]; found {
+		return key, true
+	}
+
+	if time.Since(j.lastCheck) < certReloadInterval {
+		return jose.JSONWebKey{}, false
+	}
+	j.lastCheck = time.Now()
+
+	if info, errStat := os.Stat(j.file); errStat != nil || !info.ModTime().After(j.modTime) {
+		return jose.JSONWebKey{}, false
+	}
+	if err := j.load(); err != nil {
+		log.Printf("auth: jwt: keeping current keys: reload: %v", err)
+		return jose.JSONWebKey{}, false
+	}
+
+	key, found := j.keys[kid]
+	return key, found
+}
+
+func (j *jwtAuth) authenticate(_ context.Context, authorization string) (*principal, error) {
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found {
+		return nil, nil // not bearer token
+	}
+
+	parsed, errParse := jwt.ParseSigned(token, jwtAlgorithms)
+	if errParse != nil || len(parsed.Headers) != 1 {
+		return nil, nil // not JWT, or not RS256/ES256
+	}
+
+	kid := parsed.Headers[0].KeyID
+	key, foundKey := j.key(kid)
+	if !foundKey {
+		return nil, nil // unknown key, maybe kubernetes token
+	}
+
+	var claims jwt.Claims
+	var extra map[string]any
+	if err := parsed.Claims(key.Key, &claims, &extra); err != nil {
+		return nil, fmt.Errorf("jwt: kid=%s: %w", kid, err)
+	}
+
+	if err := j.validate(claims, time.Now()); err != nil {
+		return nil, fmt.Errorf("jwt: %w", err)
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("jwt: missing claim sub")
+	}
+
+	return newPrincipal("jwt", []string{claims.Subject}, stringList(extra[j.groupsClaim])), nil
+}
+
+// validate checks time claims, issuer and audience.
+func (j *jwtAuth) validate(claims jwt.Claims, now time.Time) error {
+	if claims.Expiry == nil {
+		return errors.New("missing claim exp")
+	}
+	expected := jwt.Expected{
+		Issuer:      j.issuer,
+		AnyAudience: jwt.Audience{j.audience},
+		Time:        now,
+	}
+	return claims.ValidateWithLeeway(expected, jwtClockSkew)
+}
+
+// stringList converts claim that may be either string or list of strings.
+func stringList(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var list []string
+		for _, item := range v {
+			if s, isString := item.(string); isString {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
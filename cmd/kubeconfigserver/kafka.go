@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+/*
+Spring Cloud Bus refresh events over Kafka.
+
+Spring Cloud Bus with the Kafka binder publishes events as JSON into topic springCloudBus:
+
+{"type":"RefreshRemoteApplicationEvent","timestamp":1697000000000,"originService":"config-server:8888","destinationService":"myapp:**","id":"..."}
+
+Every replica must see every event, since each replica only knows the keys it has served.
+Hence each replica consumes with its own consumer group: <KAFKA_GROUP_PREFIX>-<hostname>.
+On shutdown the reader is closed, leaving the group. Since pod names change on
+every rollout, the empty groups of former pods are left behind until the broker
+drops their offsets after offsets.retention.minutes (7 days by default); they
+can be removed earlier with:
+
+kafka-consumer-groups.sh --bootstrap-server kafka:9092 --delete --group <KAFKA_GROUP_PREFIX>-<hostname>
+
+Events re-published by any replica of ours (see republish.go) are ignored.
+*/
+
+// kafkaReader reads messages, like *kafka.Reader.
+type kafkaReader interface {
+	ReadMessage(ctx context.Context) (kafka.Message, error)
+	Close() error
+}
+
+// kafkaRefresher delivers destinations of refresh events into channel C.
+// Channel C is closed by close.
+type kafkaRefresher struct {
+	C      chan string
+	reader kafkaReader
+	me     string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type busEvent struct {
+	Type               string `json:"type"`
+	OriginService      string `json:"originService"`
+	DestinationService string `json:"destinationService"`
+	ID                 string `json:"id"`
+}
+
+func newKafkaRefresher(brokers []string, topic, groupPrefix, me string) *kafkaRefresher {
+	groupID := groupPrefix + "-" + hostname()
+
+	log.Printf("kafka: brokers=%v topic=%s group=%s", brokers, topic, groupID)
+
+	return startKafkaRefresher(kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     brokers,
+		GroupID:     groupID,
+		Topic:       topic,
+		MaxBytes:    10e6,
+		StartOffset: kafka.LastOffset, // new consumer groups skip old events
+	}), me)
+}
+
+func startKafkaRefresher(reader kafkaReader, me string) *kafkaRefresher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := &kafkaRefresher{
+		C:      make(chan string),
+		reader: reader,
+		me:     me,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go r.run(ctx)
+
+	return r
+}
+
+func (r *kafkaRefresher) run(ctx context.Context) {
+	defer close(r.done)
+	defer close(r.C)
+
+	for {
+		m, errRead := r.reader.ReadMessage(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if errRead != nil {
+			const cooldown = 5 * time.Second
+			log.Printf("kafka: read error: %v, sleeping %v", errRead, cooldown)
+			select {
+			case <-time.After(cooldown):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		destination, found := parseBusEvent(m.Value, r.me)
+		if !found {
+			continue
+		}
+		select {
+		case r.C <- destination:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// close stops reading, then closes the reader, leaving the consumer group.
+func (r *kafkaRefresher) close() {
+	r.cancel()
+	<-r.done
+	if err := r.reader.Close(); err != nil {
+		log.Printf("kafka: close: %v", err)
+	}
+}
+
+// parseBusEvent extracts destination from RefreshRemoteApplicationEvent.
+// Events re-published by our own replicas are ignored.
+func parseBusEvent(value []byte, me string) (string, bool) {
+	// older spring cloud stream versions embed headers before the JSON payload
+	if i := bytes.IndexByte(value, '{'); i > 0 {
+		value = value[i:]
+	}
+
+	var event busEvent
+	if err := json.Unmarshal(value, &event); err != nil {
+		log.Printf("bus: bad event: %v: %q", err, value)
+		return "", false
+	}
+
+	if event.Type != "RefreshRemoteApplicationEvent" {
+		log.Printf("bus: ignoring event type='%s' origin='%s'", event.Type, event.OriginService)
+		return "", false
+	}
+
+	if ownEvent(me, event.OriginService) {
+		log.Printf("bus: ignoring own event id='%s' origin='%s'", event.ID, event.OriginService)
+		return "", false
+	}
+
+	destination := event.DestinationService
+	if destination == "" {
+		destination = "*:**" // no destination means all applications
+	}
+
+	log.Printf("bus: event id='%s' origin='%s' destination='%s'", event.ID, event.OriginService, destination)
+
+	return destination, true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestParseBusEvent(t *testing.T) {
+	for _, data := range []struct {
+		value       string
+		destination string
+		found       bool
+	}{
+		{`{"type":"RefreshRemoteApplicationEvent","originService":"config-server:8888","destinationService":"myapp:**","id":"1"}`, "myapp:**", true},
+		{`{"type":"RefreshRemoteApplicationEvent","originService":"config-server:8888","id":"2"}`, "*:**", true},
+		{"\xff\x01\x0bcontentType\x00\x00\x00\x12\"application/json\"" + `{"type":"RefreshRemoteApplicationEvent","destinationService":"other:**"}`, "other:**", true},
+		{`{"type":"AckRemoteApplicationEvent","destinationService":"myapp:**"}`, "", false},
+		{`not json`, "", false},
+		{`{"type":"RefreshRemoteApplicationEvent","originService":"kubeconfigserver:pod-1","destinationService":"myapp:**"}`, "", false},
+		{`{"type":"RefreshRemoteApplicationEvent","originService":"kubeconfigserver-other:pod-1","destinationService":"myapp:**"}`, "myapp:**", true},
+	} {
+		destination, found := parseBusEvent([]byte(data.value), "kubeconfigserver")
+		if destination != data.destination || found != data.found {
+			t.Errorf("value=%q expected destination='%s' found=%t, got destination='%s' found=%t",
+				data.value, data.destination, data.found, destination, found)
+		}
+	}
+}
+
+type fakeKafkaReader struct {
+	messages chan kafka.Message
+	closed   bool
+}
+
+func (f *fakeKafkaReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-f.messages:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (f *fakeKafkaReader) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestKafkaRefresherClose(t *testing.T) {
+	reader := &fakeKafkaReader{messages: make(chan kafka.Message, 1)}
+	r := startKafkaRefresher(reader, "kubeconfigserver")
+
+	reader.messages <- kafka.Message{Value: []byte(`{"type":"RefreshRemoteApplicationEvent","destinationService":"myapp:**"}`)}
+
+	select {
+	case destination := <-r.C:
+		if destination != "myapp:**" {
+			t.Errorf("unexpected destination: %s", destination)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event not delivered")
+	}
+
+	r.close()
+
+	if !reader.closed {
+		t.Errorf("reader not closed")
+	}
+	if _, open := <-r.C; open {
+		t.Errorf("channel not closed")
+	}
+}
@@ -6,22 +6,21 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mailgun/groupcache" //"github.com/golang/groupcache"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/udhos/boilerplate/boilerplate"
-	"github.com/udhos/kubegroup/kubegroup"
+	"github.com/udhos/kubecloudconfigserver/configpb"
 	"github.com/udhos/otelconfig/oteltrace"
-	"github.com/udhos/refresh/refresh"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
-	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -32,8 +31,17 @@ type application struct {
 	serverHealth     *serverGin
 	serverMetrics    *serverGin
 	serverGroupcache *serverHTTP
+	serverGrpc       *serverGrpc
 	me               string
 	config           appConfig
+
+	ready *readiness
+	watch *watchHub
+	peers *peerStatus
+
+	stopDiscovery func() // stops peer discovery, waiting for it to return
+
+	kafka *kafkaRefresher // nil unless KAFKA_BROKERS is set
 }
 
 func main() {
@@ -61,9 +69,47 @@ func main() {
 	log.Printf("backend directory:                export BACKEND=dir:samples")
 	log.Printf("backend directory option flatten: export BACKEND_OPTIONS=flatten")
 	log.Printf("disable refresh:                  export REFRESH=false")
+	log.Printf("standalone without kubernetes:    export STANDALONE=true GROUPCACHE_PEERS=http://10.0.0.2:5000 GROUPCACHE_PEERS_DNS=peers.local GROUPCACHE_MY_URL=http://10.0.0.1:5000")
+	log.Printf("peer discovery:                   export PEER_DISCOVERY=kubernetes|static|dns|dns-srv GROUPCACHE_PEERS_DNS=_groupcache._tcp.kubeconfigserver-peers GROUPCACHE_PEERS_DNS_INTERVAL=5s")
+	log.Printf("graceful shutdown:                export SHUTDOWN_DRAIN=10s SHUTDOWN_TIMEOUT=10s")
+	log.Printf("readiness probe:                  export READINESS_PATH=/ready READINESS_REQUIRE_AMQP=false WARMUP_PATHS=/app-default.yml,/app-prod.yml")
+	log.Printf("content types:                    export CONTENT_TYPES=.yml=application/x-yaml,.conf=text/plain")
+	log.Printf("rate limits:                      export RATE_LIMIT=10 RATE_BURST=20 MAX_IN_FLIGHT=100 MAX_WATCHES=1000 TRUSTED_PROXIES=10.0.0.0/8 ;# 0 disables")
+	log.Printf("redact secrets:                   export REDACT=true REDACT_KEYS=password,secret,token REDACT_PRIVILEGED=jwt:group:admins")
+	log.Printf("tls:                              export TLS_CERT_FILE=tls.crt TLS_KEY_FILE=tls.key ;# reloaded when modified")
+	log.Printf("mutual tls:                       export TLS_CLIENT_CA_FILE=ca.crt TLS_CLIENT_CERT_OPTIONAL=false")
+	log.Printf("client auth:                      export AUTH_BASIC_FILE=users AUTH_TOKENS_FILE=tokens AUTH_JWKS_FILE=jwks.json AUTH_JWT_AUDIENCE=config AUTH_KUBERNETES=true")
+	log.Printf("client auth rules:                export AUTH_RULES=rules.yaml")
+	log.Printf("grpc api:                         export GRPC_ADDR=:9090 ;# empty (default) disables")
+	log.Printf("watch long-poll/sse limits:       export WATCH_MAX_WAIT=60s WATCH_RECHECK=10s")
+	log.Printf("ttl while amqp is down:           export REFRESH_FALLBACK_TTL=300s ;# 0 disables")
+	log.Printf("admin endpoints:                  export ADMIN_PATH=/admin ADMIN_TOKEN=secret ;# empty token disables")
+	log.Printf("refresh audit history:            export AUDIT_SIZE=100 ;# GET :8888/admin/refresh/history")
+	log.Printf("groupcache peer status:           curl -H \"Authorization: Bearer $ADMIN_TOKEN\" localhost:8888/admin/peers")
+	log.Printf("poll backend for changes:         export POLL_INTERVAL=60s ;# 0 disables")
+	log.Printf("republish refresh events:         export REPUBLISH_WEBHOOK_URL=http://hook REPUBLISH_AMQP_EXCHANGE=configChanged REPUBLISH_KAFKA_TOPIC=configChanged")
+	log.Printf("kafka refresh events:             export KAFKA_BROKERS=kafka:9092 KAFKA_TOPIC=springCloudBus")
+	log.Printf("http refresh endpoint:            export BUSREFRESH_PATH=/actuator/busrefresh BUSREFRESH_TOKEN=secret ;# empty token disables")
+	log.Printf("git webhook monitor:              export MONITOR_PATH=/monitor MONITOR_SECRET=secret ;# empty secret disables")
+	log.Printf("max object size:                  export MAX_OBJECT_SIZE=32MiB ;# 0 means unlimited (default)")
+	log.Printf("disable cache:                    export CACHE=false NOCACHE_TTL=1s")
+	log.Printf("groupcache memory size:           export GROUPCACHE_SIZE=64MiB")
+	log.Printf("compress entries larger than:     export COMPRESS_MIN_SIZE=4096 ;# 0 disables")
+	log.Printf("groupcache extra groups:          export GROUPCACHE_GROUPS=bundles:256MiB:/bundles/[:backend],...")
 
 	app.config = newConfig(app.me)
 
+	tlsConfig, errTLS := newTLSConfig(app.config)
+	if errTLS != nil {
+		log.Fatalf("tls: %v", errTLS)
+	}
+
+	// clientAuth is nil when client authentication is disabled
+	clientAuth, errAuth := newAuth(app.config, tlsConfig)
+	if errAuth != nil {
+		log.Fatalf("auth: %v", errAuth)
+	}
+
 	//
 	// initialize tracing
 	//
@@ -92,83 +138,51 @@ func main() {
 	// create backend
 	//
 
-	storage := newBackend(tracer, app.config.backendAddr, app.config.backendOptions)
+	storage := newBackend(tracer, app.config.backendAddr, app.config.backendOptions, app.config.maxObjectSize)
 
 	//
 	// create groupcache pool
 	//
 
-	var myURL string
-	for myURL == "" {
-		var errURL error
-		myURL, errURL = kubegroup.FindMyURL(app.config.groupcachePort)
-		if errURL != nil {
-			log.Printf("my URL: %v", errURL)
-		}
-		if myURL == "" {
-			const cooldown = 5 * time.Second
-			log.Printf("could not find my URL, sleeping %v", cooldown)
-			time.Sleep(cooldown)
-		}
-		//log.Printf("u=%v err=%v", myURL, errURL)
-	}
+	myURL := findMyURL(app.config)
 
 	log.Printf("groupcache my URL: %s", myURL)
 
 	pool := groupcache.NewHTTPPoolOpts(myURL, &groupcache.HTTPPoolOptions{})
 
+	// peers records membership and counts requests to peers
+	peers := newPeerStatus(pool, myURL, app.config.peerDiscovery)
+	pool.Transport = peers.transport
+	app.peers = peers
+
 	//
-	// start groupcache server
+	// start watcher for addresses of peers
 	//
 
-	app.serverGroupcache = newServerHTTP(app.config.groupcachePort, pool)
+	discovery, errDiscovery := newPeerDiscovery(app.config, peers, myURL)
+	if errDiscovery != nil {
+		log.Fatalf("peer discovery: %v", errDiscovery)
+	}
 
+	log.Printf("peer discovery: %s", app.config.peerDiscovery)
+	discoveryCtx, stopDiscovery := context.WithCancel(context.Background())
+	discoveryDone := make(chan struct{})
 	go func() {
-		log.Printf("groupcache server: listening on %s", app.config.groupcachePort)
-		err := app.serverGroupcache.server.ListenAndServe()
-		log.Printf("groupcache server: exited: %v", err)
+		discovery.run(discoveryCtx)
+		close(discoveryDone)
 	}()
-
-	//
-	// start watcher for addresses of peers
-	//
-
-	go kubegroup.UpdatePeers(pool, app.config.groupcachePort)
+	app.stopDiscovery = func() {
+		stopDiscovery()
+		<-discoveryDone
+	}
 
 	// https://talks.golang.org/2013/oscon-dl.slide#46
 	//
-	// 64 MB max per-node memory usage
-	configFiles := groupcache.NewGroup("configfiles", 64<<20, groupcache.GetterFunc(
-		func(ctx groupcache.Context, filename string, dest groupcache.Sink) error {
-			/*
-				begin := time.Now()
-				data, errFetch := storage.fetch(ctx.(context.Context), filename)
-				if errFetch != nil {
-					log.Printf("fetch: filename='%s': error: %v", filename, errFetch)
-					return errFetch
-				}
-				elap := time.Since(begin)
-				log.Printf("fetch: filename='%s': size:%d elapsed:%v", filename, len(data), elap)
-			*/
-
-			var newCtx context.Context
-			if ctx == nil {
-				newCtx = context.Background()
-			} else {
-				newCtx = ctx.(context.Context)
-			}
+	// GROUPCACHE_SIZE sets max per-node memory usage for default group.
+	// GROUPCACHE_GROUPS creates additional groups for specific path prefixes.
+	configFiles := newCacheGroups(tracer, app.config, storage)
 
-			data, errFetch := fetch(newCtx, storage, filename)
-			if errFetch != nil {
-				return errFetch
-			}
-			var expire time.Time // zero value for expire means no expiration
-			if app.config.ttl != 0 {
-				expire = time.Now().Add(app.config.ttl)
-			}
-			dest.SetBytes(data, expire)
-			return nil
-		}))
+	prometheus.MustRegister(newGroupcacheCollector(configFiles))
 
 	// tableKeys is used to find which key should be removed for a refresh notification
 	// Example:
@@ -176,99 +190,204 @@ func main() {
 	// then should remove cache key: /path/to/config1-default.yml,config2-default.yml,config3-default.yml
 	tableKeys := newTable()
 
+	origin := app.me + ":" + hostname()
+
+	// health tracks components running in degraded mode
+	health := newHealthStatus()
+
+	// audit keeps recent refresh events for the admin endpoint
+	audit := newRefreshAudit(hostname(), app.config.auditSize)
+
+	// watch wakes up clients watching invalidated keys
+	watch := newWatchHub()
+	app.watch = watch
+
+	inv := &invalidator{
+		groups:      configFiles,
+		keys:        tableKeys,
+		audit:       audit,
+		republisher: newRepublisher(origin, app.config),
+		watch:       watch,
+		elect: func(destination string) bool {
+			// the replica owning destination in the groupcache ring
+			_, remote := pool.PickPeer(destination)
+			return !remote
+		},
+	}
+
+	if app.config.pollInterval > 0 {
+		go newPoller(inv, pool, app.config.pollInterval).run()
+	}
+
+	// busRefresh broadcasts HTTP refresh requests to peers
+	// peers sign broadcasts with BUSREFRESH_TOKEN, or else MONITOR_SECRET,
+	// since either endpoint may start a broadcast
+	peerSecret := app.config.busRefreshToken
+	if peerSecret == "" {
+		peerSecret = app.config.monitorSecret
+	}
+	busRefresher := newBusRefresh(inv, pool, app.config.busRefreshToken, peerSecret)
+
+	//
+	// start groupcache server
+	//
+
+	app.serverGroupcache = newServerHTTP(app.config.groupcachePort, busRefresher.peerHandler(pool))
+
+	go func() {
+		log.Printf("groupcache server: listening on %s", app.config.groupcachePort)
+		err := app.serverGroupcache.server.ListenAndServe()
+		log.Printf("groupcache server: exited: %v", err)
+	}()
+
 	//
 	// receive refresh events
 	//
 
 	if app.config.refreshEnabled {
-		// "#" means receive notification for all applications
-		refresher := refresh.New(app.config.refreshAmqpURL, app.me, []string{"#"}, app.config.debug, nil)
+		r := &amqpRefresher{
+			amqpURL:     app.config.refreshAmqpURL,
+			me:          app.me,
+			debug:       app.config.debug,
+			inv:         inv,
+			health:      health,
+			ttl:         app.config.ttl,
+			fallbackTTL: app.config.refreshFallbackTTL,
+		}
+		go r.run()
+	}
+
+	if len(app.config.kafkaBrokers) > 0 {
+		kafkaRefresher := newKafkaRefresher(app.config.kafkaBrokers,
+			app.config.kafkaTopic, app.config.kafkaGroupPrefix, app.me)
+		app.kafka = kafkaRefresher
 
 		go func() {
-			for app := range refresher.C {
-				// app = "config-cli-example:**"
-				log.Printf("refresh: received notification for application='%s'", app)
-				for _, key := range tableKeys.match(app) {
-					log.Printf("refresh: removing key='%s' for application='%s'", key, app)
-					if errRemove := configFiles.Remove(context.TODO(), key); errRemove != nil {
-						log.Printf("refresh: removing key='%s' for application='%s': error: %v",
-							key, app, errRemove)
-						continue
-					}
-					tableKeys.del(key)
-				}
+			for application := range kafkaRefresher.C {
+				inv.refreshAndPublish(context.TODO(), "kafka", application)
 			}
-
-			log.Fatal("refresh channel has been closed")
 		}()
 	}
 
+	// secrets masks secret values in responses and request logs
+	secrets := newRedactor(app.config.redactKeys, app.config.redactPrivileged)
+
+	// configs serves config files from cache
+	configs := &configServer{
+		tracer:  tracer,
+		groups:  configFiles,
+		keys:    tableKeys,
+		noCache: newCoalescer(storage, app.config.noCacheTTL), // used when CACHE=false
+		cache:   app.config.cache,
+
+		watch:        watch,
+		watchMaxWait: app.config.watchMaxWait,
+		watchRecheck: app.config.watchRecheck,
+
+		contentTypes: app.config.contentTypes,
+	}
+
+	if app.config.redact {
+		configs.redact = secrets
+	}
+
 	//
 	// register application routes
 	//
 
 	app.serverMain = newServerGin(app.config.applicationAddr)
+	app.serverMain.server.TLSConfig = tlsConfig
 	app.serverMain.router.Use(metricsMiddleware())
-	app.serverMain.router.Use(gin.Logger())
+	app.serverMain.router.Use(gin.LoggerWithFormatter(secrets.logFormatter))
 	app.serverMain.router.Use(otelgin.Middleware(app.me))
 
-	const pathAny = "/*anything"
-	log.Printf("registering route: %s %s", app.config.applicationAddr, pathAny)
-	app.serverMain.router.GET(pathAny, func(c *gin.Context) {
+	// X-Forwarded-For is only trusted from TRUSTED_PROXIES
+	if errProxies := app.serverMain.router.SetTrustedProxies(app.config.trustedProxies); errProxies != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", errProxies)
+	}
 
-		path := c.Param("anything")
+	limits := newRateLimits(app.config.rateLimit, app.config.rateBurst, app.config.maxInFlight, app.config.maxWatches)
+	app.serverMain.router.Use(limits.maxInFlight)
+
+	switch {
+	case app.config.monitorPath == "":
+	case app.config.monitorSecret == "":
+		log.Printf("monitor: MONITOR_SECRET is empty, endpoint %s disabled", app.config.monitorPath)
+	default:
+		log.Printf("registering route: %s POST %s", app.config.applicationAddr, app.config.monitorPath)
+		if app.config.tlsClientCAFile != "" && !app.config.tlsClientCertOptional {
+			log.Printf("monitor: WARNING: TLS_CLIENT_CA_FILE requires client certificates, git webhooks can't reach %s, consider TLS_CLIENT_CERT_OPTIONAL=true",
+				app.config.monitorPath)
+		}
+		monitor := newMonitorHandler(busRefresher, app.config.monitorSecret)
+		app.serverMain.router.POST(app.config.monitorPath, limits.perIP, monitor.handle)
+	}
 
-		ctx := c.Request.Context()
+	if app.config.busRefreshToken == "" {
+		log.Printf("busrefresh: BUSREFRESH_TOKEN is empty, endpoint %s disabled", app.config.busRefreshPath)
+	} else {
+		pathDestination := app.config.busRefreshPath + "/:destination"
+		log.Printf("registering route: %s POST %s", app.config.applicationAddr, app.config.busRefreshPath)
+		log.Printf("registering route: %s POST %s", app.config.applicationAddr, pathDestination)
+		app.serverMain.router.POST(app.config.busRefreshPath, limits.perIP, busRefresher.handle)
+		app.serverMain.router.POST(pathDestination, limits.perIP, busRefresher.handle)
+	}
 
-		newCtx, span := tracer.Start(ctx, "request")
-		defer span.End()
+	const pathAny = "/*anything"
+	log.Printf("registering route: %s GET/HEAD/OPTIONS %s", app.config.applicationAddr, pathAny)
+	handlers := []gin.HandlerFunc{limits.perIP}
+	if clientAuth != nil {
+		handlers = append(handlers, clientAuth.middleware, limits.perClient)
+	}
+	handlers = append(handlers, configs.handle)
+	app.serverMain.router.GET(pathAny, handlers...)
+	app.serverMain.router.HEAD(pathAny, handlers...)
+	app.serverMain.router.OPTIONS(pathAny, limits.perIP, handleOptions)
 
-		log.Printf("traceID=%s", span.SpanContext().TraceID())
+	//
+	// start application server
+	//
 
-		if !app.config.cache {
-			// cache disabled
-			data, errFetch := fetch(ctx, storage, path)
-			if errFetch != nil {
-				span.SetStatus(codes.Error, errFetch.Error())
-				c.String(http.StatusInternalServerError, "fetch error")
-				return
-			}
+	go func() {
+		log.Printf("application server: listening on %s tls=%t", app.config.applicationAddr, tlsConfig != nil)
+		err := app.serverMain.listenAndServe()
+		log.Printf("application server: exited: %v", err)
+	}()
 
-			c.Data(http.StatusOK, http.DetectContentType(data), data)
-			return
-		}
+	//
+	// warm up cache
+	//
 
-		var data []byte
-		errGet := configFiles.Get(newCtx, path, groupcache.AllocatingByteSliceSink(&data))
-		log.Printf("groupcache.Get: path='%s' error:%v", path, errGet)
-		if errGet != nil {
-			if errBackend, isBackend := errGet.(backendError); isBackend {
-				span.SetStatus(codes.Error, errBackend.Error())
-				sendBackendError(c, errBackend)
-				return
-			}
-			span.SetStatus(codes.Error, errGet.Error())
-			c.String(http.StatusInternalServerError, "server error")
-			return
-		}
+	admin := &adminAuth{token: app.config.adminToken}
 
-		tableKeys.add(path) // record path
+	ready := &readiness{
+		backend:     storage,
+		peers:       func() int { return len(pool.GetAll()) },
+		health:      health,
+		amqp:        app.config.refreshEnabled,
+		requireAmqp: app.config.readinessRequireAmqp,
+		admin:       admin,
+		warmupTotal: len(app.config.warmupPaths),
+	}
 
-		log.Printf("stats main: %#v", configFiles.CacheStats(groupcache.MainCache))
-		log.Printf("stats hot:  %#v", configFiles.CacheStats(groupcache.HotCache))
+	app.ready = ready
 
-		c.Data(http.StatusOK, http.DetectContentType(data), data)
-	})
+	go ready.warmUp(context.Background(), configs, app.config.warmupPaths, time.Second)
 
 	//
-	// start application server
+	// start grpc server
 	//
 
-	go func() {
-		log.Printf("application server: listening on %s", app.config.applicationAddr)
-		err := app.serverMain.server.ListenAndServe()
-		log.Printf("application server: exited: %v", err)
-	}()
+	if app.config.grpcAddr != "" {
+		app.serverGrpc = newServerGrpc(app.config.grpcAddr, tlsConfig, limits)
+		configpb.RegisterConfigServerServer(app.serverGrpc.server, &grpcServer{configs: configs, auth: clientAuth, limits: limits})
+
+		go func() {
+			log.Printf("grpc server: listening on %s tls=%t", app.config.grpcAddr, tlsConfig != nil)
+			err := app.serverGrpc.listenAndServe()
+			log.Printf("grpc server: exited: %v", err)
+		}()
+	}
 
 	//
 	// start health server
@@ -277,9 +396,22 @@ func main() {
 	app.serverHealth = newServerGin(app.config.healthAddr)
 
 	log.Printf("registering route: %s %s", app.config.healthAddr, app.config.healthPath)
-	app.serverHealth.router.GET(app.config.healthPath, func(c *gin.Context) {
-		c.String(http.StatusOK, "health ok")
-	})
+	app.serverHealth.router.GET(app.config.healthPath, health.handle)
+
+	log.Printf("registering route: %s %s", app.config.healthAddr, app.config.readinessPath)
+	app.serverHealth.router.GET(app.config.readinessPath, ready.handle)
+
+	if app.config.adminToken == "" {
+		log.Printf("admin: ADMIN_TOKEN is empty, endpoints %s/* disabled", app.config.adminPath)
+	} else {
+		pathHistory := app.config.adminPath + "/refresh/history"
+		log.Printf("registering route: %s %s", app.config.healthAddr, pathHistory)
+		app.serverHealth.router.GET(pathHistory, admin.middleware, audit.handleHistory)
+
+		pathPeers := app.config.adminPath + "/peers"
+		log.Printf("registering route: %s %s", app.config.healthAddr, pathPeers)
+		app.serverHealth.router.GET(pathPeers, admin.middleware, peers.handle)
+	}
 
 	go func() {
 		log.Printf("health server: listening on %s", app.config.healthAddr)
@@ -322,6 +454,14 @@ func fetch(ctx context.Context, storage backend, filename string) ([]byte, error
 	return data, nil
 }
 
+// shutdown stops the servers in an order that avoids refused connections:
+// readiness fails first, so that kubernetes stops routing requests to this pod
+// and peers remove it from their groupcache pools; after SHUTDOWN_DRAIN the
+// application servers finish in-flight requests; the groupcache server stops
+// last, since peers may still be routing keys here.
+//
+// All servers share a single SHUTDOWN_TIMEOUT deadline, hence shutdown takes
+// at most SHUTDOWN_DRAIN + SHUTDOWN_TIMEOUT.
 func shutdown(app *application) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -329,11 +469,53 @@ func shutdown(app *application) {
 
 	log.Printf("received signal '%v', initiating shutdown", sig)
 
-	const timeout = 5 * time.Second
-	app.serverHealth.shutdown(timeout)
-	app.serverMetrics.shutdown(timeout)
-	app.serverMain.shutdown(timeout)
-	app.serverGroupcache.shutdown(timeout)
+	app.ready.shutdown()
+
+	log.Printf("shutdown: readiness failing, draining for %v", app.config.shutdownDrain)
+	time.Sleep(app.config.shutdownDrain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+	defer cancel()
+
+	app.watch.close() // release long-polls and streams
+
+	var wg sync.WaitGroup
+	stop := func(shutdown func(ctx context.Context)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shutdown(ctx)
+		}()
+	}
+
+	stop(app.serverMain.shutdown)
+	if app.serverGrpc != nil {
+		stop(app.serverGrpc.shutdown)
+	}
+	stop(app.serverHealth.shutdown)
+	stop(app.serverMetrics.shutdown)
+	wg.Wait()
+
+	if app.kafka != nil {
+		log.Print("shutdown: closing kafka reader")
+		app.kafka.close()
+	}
+
+	// stop forwarding keys to peers, this pod's own pool only;
+	// discovery stops first, so it can't restore the peers
+	log.Print("shutdown: clearing groupcache peers")
+	app.stopDiscovery()
+	app.peers.Set()
+
+	app.serverGroupcache.shutdown(ctx)
 
 	log.Print("exiting")
 }
+
+func hostname() string {
+	host, errHost := os.Hostname()
+	if errHost != nil {
+		log.Printf("hostname: %v", errHost)
+	}
+	return host
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -14,17 +15,57 @@ var testMatchTable = []testMatch{
 	{"config-file2:**", "", false},
 	{"config:file2:**", "", false},
 	{"config-file2:**", "config-file2-default.yml", true},
-	{"config:file2:**", "config-file2-default.yml", true},
+	{"config:file2:**", "config-file2-default.yml", false},
 	{"config-file4:**", "config-file2-default.yml", false},
 	{"config:file4:**", "config-file2-default.yml", false},
 	{"config-file2:**", "/path/to/config-file2-default.yml", true},
-	{"config:file2:**", "/path/to/config-file2-default.yml", true},
+	{"config:file2:**", "/path/to/config-file2-default.yml", false},
 	{"config-file4:**", "/path/to/config-file2-default.yml", false},
 	{"config:file4:**", "/path/to/config-file2-default.yml", false},
 	{"config-file2:**", "/path/to/config-file1-default.yml,config-file2-default.yml,config-file3-default.yml", true},
-	{"config:file2:**", "/path/to/config-file1-default.yml,config-file2-default.yml,config-file3-default.yml", true},
+	{"config:file2:**", "/path/to/config-file1-default.yml,config-file2-default.yml,config-file3-default.yml", false},
 	{"config-file4:**", "/path/to/config-file1-default.yml,config-file2-default.yml,config-file3-default.yml", false},
 	{"config:file4:**", "/path/to/config-file1-default.yml,config-file2-default.yml,config-file3-default.yml", false},
+
+	// application name is no longer a raw string prefix
+	{"config:**", "config-file2-default.yml", false},
+	{"config", "config-file2-default.yml", false},
+	{"config-file2", "config-file2-default.yml", true},
+
+	// file names are split at the last "-"
+	{"myapp-blue:green", "/myapp-blue-green.yml", true},
+	{"myapp:blue-green", "/myapp-blue-green.yml", false},
+	{"myapp", "/myapp-blue-green.yml", false},
+
+	// profiles
+	{"config-file2:default:**", "config-file2-default.yml", true},
+	{"config-file2:default", "config-file2-default.yml", true},
+	{"config-file2:dev:**", "config-file2-default.yml", false},
+	{"config-file2:*", "config-file2-default.yml", true},
+	{"*:default:**", "config-file2-default.yml", true},
+	{"*:dev:**", "config-file2-default.yml", false},
+	{"app:dev:**", "/app/dev,prod", true},
+	{"app:prod:**", "/app/dev,prod", true},
+	{"app:test:**", "/app/dev,prod", false},
+	{"app", "/app.yml", true},
+
+	// labels
+	{"app:dev:main", "/app/dev/main", true},
+	{"app:dev:other", "/app/dev/main", false},
+	{"app:dev:**", "/app/dev/main", true},
+	{"app:*", "/app/dev/main", false},
+	{"app:*:*", "/app/dev/main", true},
+	{"app:dev:main", "/main/app-dev.yml", true},
+	{"app:dev:1.0.x", "/app/dev/1.0.x", true},
+	{"app:dev:1.0.x", "/1.0.x/app-dev.yml", true},
+	{"app:dev:1.0.y", "/app/dev/1.0.x", false},
+
+	// wildcards
+	{"*", "config-file2-default.yml", true},
+	{"**", "config-file2-default.yml", true},
+	{"*:**", "/app/dev/main", true},
+	{"config-*:**", "config-file2-default.yml", true},
+	{"other-*:**", "config-file2-default.yml", false},
 }
 
 func TestMatch(t *testing.T) {
@@ -36,3 +77,26 @@ func TestMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestParseKey(t *testing.T) {
+	for _, data := range []struct {
+		key      string
+		expected [][]string
+	}{
+		{"", nil},
+		{"/app/dev", [][]string{{"app", "dev"}}},
+		{"/app/dev,prod/main", [][]string{{"app", "dev", "main"}, {"app", "prod", "main"}}},
+		{"/app/dev/1.0.x", [][]string{{"app", "dev", "1.0.x"}}},
+		{"/app-dev.yml", [][]string{{"app", "dev"}}},
+		{"/app.yml", [][]string{{"app", "default"}}},
+		{"/main/app-dev.yml", [][]string{{"app", "dev", "main"}}},
+		{"/1.0.x/app-dev.properties", [][]string{{"app", "dev", "1.0.x"}}},
+		{"/myapp-blue-green.yml", [][]string{{"myapp-blue", "green"}}},
+		{"/path/to/config-file1-default.yml,config-file2-default.yml", [][]string{{"config-file1", "default"}, {"config-file2", "default"}}},
+	} {
+		result := parseKey(data.key)
+		if !reflect.DeepEqual(result, data.expected) {
+			t.Errorf("key='%s' expected=%v got=%v", data.key, data.expected, result)
+		}
+	}
+}
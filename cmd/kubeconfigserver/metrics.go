@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mailgun/groupcache"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -21,6 +22,71 @@ var (
 		Name: "http_server_requests_seconds_sum",
 		Help: "Sum of the the duration of every request",
 	}, dimensionsSpring)
+
+	backendTooLarge = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backend_object_too_large_total",
+		Help: "Number of backend objects rejected for exceeding MAX_OBJECT_SIZE",
+	}, []string{"backend"})
+
+	amqpUp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "amqp_refresh_up",
+		Help: "AMQP refresh channel state: 1 means connected, 0 means disconnected",
+	})
+
+	amqpReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "amqp_refresh_reconnects_total",
+		Help: "Number of reconnections after AMQP refresh channel was closed",
+	})
+
+	pollChanges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poll_changes_total",
+		Help: "Number of keys invalidated by backend polling due to content change",
+	})
+
+	pollErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poll_errors_total",
+		Help: "Number of backend polling fetch errors",
+	})
+
+	watchClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "watch_clients",
+		Help: "Number of clients currently watching config files with long-poll or Server-Sent Events",
+	})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limited_requests_total",
+		Help: "Number of requests rejected with status 429, by reason: client or in_flight",
+	}, []string{"reason"})
+
+	inFlightRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "in_flight_requests",
+		Help: "Number of application requests currently in flight, excluding watch requests",
+	})
+
+	inFlightWatches = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "in_flight_watches",
+		Help: "Number of watch requests and gRPC Watch streams currently open",
+	})
+
+	peersGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "groupcache_peers",
+		Help: "Number of groupcache peers, including this server",
+	})
+
+	peersChanged = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "groupcache_peers_last_change_timestamp_seconds",
+		Help: "Unix time of last groupcache peer membership change",
+	})
+
+	peerRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "groupcache_peer_requests_total",
+		Help: "Number of requests sent to groupcache peer",
+	}, []string{"peer"})
+
+	peerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "groupcache_peer_request_errors_total",
+		Help: "Number of failed requests sent to groupcache peer",
+	}, []string{"peer"})
 )
 
 func metricsMiddleware() gin.HandlerFunc {
@@ -42,3 +108,99 @@ func handlerMetrics(c *gin.Context) {
 	springRequestsCount.WithLabelValues(c.Request.Method, status, path).Inc()
 	springRequestsDuration.WithLabelValues(c.Request.Method, status, path).Add(elapsedSeconds)
 }
+
+// groupcacheCollector exports groupcache statistics as prometheus metrics.
+type groupcacheCollector struct {
+	groups *cacheGroups
+
+	groupStats []groupStatDesc
+	cacheStats []cacheStatDesc
+}
+
+type groupStatDesc struct {
+	desc  *prometheus.Desc
+	value func(s *groupcache.Stats) int64
+}
+
+type cacheStatDesc struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(s groupcache.CacheStats) int64
+}
+
+func newGroupcacheCollector(groups *cacheGroups) *groupcacheCollector {
+	groupLabels := []string{"group"}
+	cacheLabels := []string{"group", "cache"}
+
+	gs := func(name, help string, value func(s *groupcache.Stats) int64) groupStatDesc {
+		return groupStatDesc{
+			desc:  prometheus.NewDesc("groupcache_"+name, help, groupLabels, nil),
+			value: value,
+		}
+	}
+
+	cs := func(name, help string, valueType prometheus.ValueType, value func(s groupcache.CacheStats) int64) cacheStatDesc {
+		return cacheStatDesc{
+			desc:      prometheus.NewDesc("groupcache_cache_"+name, help, cacheLabels, nil),
+			valueType: valueType,
+			value:     value,
+		}
+	}
+
+	return &groupcacheCollector{
+		groups: groups,
+		groupStats: []groupStatDesc{
+			gs("gets_total", "Any Get request, including from peers", func(s *groupcache.Stats) int64 { return s.Gets.Get() }),
+			gs("hits_total", "Either cache was good", func(s *groupcache.Stats) int64 { return s.CacheHits.Get() }),
+			gs("peer_loads_total", "Either remote load or remote cache hit (not an error)", func(s *groupcache.Stats) int64 { return s.PeerLoads.Get() }),
+			gs("peer_errors_total", "Errors loading from peers", func(s *groupcache.Stats) int64 { return s.PeerErrors.Get() }),
+			gs("loads_total", "Gets minus cache hits", func(s *groupcache.Stats) int64 { return s.Loads.Get() }),
+			gs("loads_deduped_total", "Loads after singleflight", func(s *groupcache.Stats) int64 { return s.LoadsDeduped.Get() }),
+			gs("local_loads_total", "Total good local loads", func(s *groupcache.Stats) int64 { return s.LocalLoads.Get() }),
+			gs("local_load_errors_total", "Total bad local loads", func(s *groupcache.Stats) int64 { return s.LocalLoadErrs.Get() }),
+			gs("server_requests_total", "Gets that came over the network from peers", func(s *groupcache.Stats) int64 { return s.ServerRequests.Get() }),
+		},
+		cacheStats: []cacheStatDesc{
+			cs("gets_total", "Cache gets", prometheus.CounterValue, func(s groupcache.CacheStats) int64 { return s.Gets }),
+			cs("hits_total", "Cache hits", prometheus.CounterValue, func(s groupcache.CacheStats) int64 { return s.Hits }),
+			cs("evictions_total", "Cache evictions", prometheus.CounterValue, func(s groupcache.CacheStats) int64 { return s.Evictions }),
+			cs("bytes", "Cache size in bytes", prometheus.GaugeValue, func(s groupcache.CacheStats) int64 { return s.Bytes }),
+			cs("items", "Number of cache items", prometheus.GaugeValue, func(s groupcache.CacheStats) int64 { return s.Items }),
+		},
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *groupcacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, s := range c.groupStats {
+		ch <- s.desc
+	}
+	for _, s := range c.cacheStats {
+		ch <- s.desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *groupcacheCollector) Collect(ch chan<- prometheus.Metric) {
+	caches := []struct {
+		name  string
+		cache groupcache.CacheType
+	}{
+		{"main", groupcache.MainCache},
+		{"hot", groupcache.HotCache},
+	}
+	for _, g := range c.groups.groups {
+		name := g.group.Name()
+		for _, s := range c.groupStats {
+			ch <- prometheus.MustNewConstMetric(s.desc, prometheus.CounterValue,
+				float64(s.value(&g.group.Stats)), name)
+		}
+		for _, cache := range caches {
+			stats := g.group.CacheStats(cache.cache)
+			for _, s := range c.cacheStats {
+				ch <- prometheus.MustNewConstMetric(s.desc, s.valueType,
+					float64(s.value(stats)), name, cache.name)
+			}
+		}
+	}
+}
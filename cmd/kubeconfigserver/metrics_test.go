@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestGroupcacheCollector(t *testing.T) {
+	tracer := trace.NewNoopTracerProvider().Tracer("test")
+	config := appConfig{
+		groupcacheSize:   1 << 20,
+		groupcacheGroups: "collector-test:1MiB:/bundles/",
+	}
+	storage := newBackendDir(tracer, "../../samples", "", 0)
+	groups := newCacheGroups(tracer, config, storage)
+
+	// 9 group stats + 5 stats for each of 2 caches, per group
+	const expected = 2 * (9 + 2*5)
+
+	collector := newGroupcacheCollector(groups)
+
+	if count := testutil.CollectAndCount(collector); count != expected {
+		t.Errorf("expected %d metrics, got %d", expected, count)
+	}
+
+	// register next to the promauto metrics, as main does,
+	// to catch metric name clashes
+	if err := prometheus.DefaultRegisterer.Register(collector); err != nil {
+		t.Fatalf("register collector: %v", err)
+	}
+	defer prometheus.DefaultRegisterer.Unregister(collector)
+
+	if _, err := prometheus.DefaultGatherer.Gather(); err != nil {
+		t.Errorf("gather: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Spring Cloud Config Monitor compatible endpoint.
+
+Git push webhooks from GitHub, GitLab, Bitbucket and Gitea are accepted.
+The application names are guessed from the modified file paths, then the
+matching cache keys are invalidated on all groupcache peers, using the
+busrefresh broadcast.
+
+The endpoint is only enabled when MONITOR_SECRET is defined.
+
+Git hosting services don't present client certificates, hence with
+TLS_CLIENT_CA_FILE the endpoint requires TLS_CLIENT_CERT_OPTIONAL=true.
+Webhooks are still verified by MONITOR_SECRET, and config files still
+require authentication.
+
+Plain requests with form parameter path are accepted as well:
+
+curl -X POST localhost:8080/monitor -d path=config-file2-default.yml
+*/
+
+// monitorMaxBody limits the size of webhook payloads.
+const monitorMaxBody = 10 << 20
+
+type monitorHandler struct {
+	bus    *busRefresh
+	secret string
+}
+
+func newMonitorHandler(bus *busRefresh, secret string) *monitorHandler {
+	return &monitorHandler{bus: bus, secret: secret}
+}
+
+// monitorAuthError reports webhook request failing signature or token verification.
+type monitorAuthError struct {
+	err error
+}
+
+func (e monitorAuthError) Error() string {
+	return e.err.Error()
+}
+
+func (m *monitorHandler) handle(c *gin.Context) {
+	body, errBody := io.ReadAll(io.LimitReader(c.Request.Body, monitorMaxBody))
+	if errBody != nil {
+		log.Printf("monitor: read body: %v", errBody)
+		c.String(http.StatusBadRequest, "bad request body")
+		return
+	}
+
+	paths, errPaths := m.extractPaths(c.Request, body)
+	if errPaths != nil {
+		log.Printf("monitor: %v", errPaths)
+		if _, unauthorized := errPaths.(monitorAuthError); unauthorized {
+			c.String(http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		c.String(http.StatusBadRequest, "bad webhook request")
+		return
+	}
+
+	destinations := guessDestinations(paths)
+
+	log.Printf("monitor: paths=%v destinations=%v", paths, destinations)
+
+	status := http.StatusOK
+	for _, d := range destinations {
+		if _, errs := m.bus.broadcast(c.Request.Context(), "monitor", d); len(errs) > 0 {
+			status = http.StatusBadGateway
+		}
+		// peers have re-fetched their keys, which are unknown here
+		m.bus.inv.publish(c.Request.Context(), d, nil)
+	}
+
+	c.JSON(status, destinations)
+}
+
+// extractPaths finds modified files from webhook request.
+// The request signature is verified against the secret.
+// Verification failures are reported as monitorAuthError.
+func (m *monitorHandler) extractPaths(r *http.Request, body []byte) ([]string, error) {
+	h := r.Header
+
+	switch {
+	case h.Get("X-Gitea-Event") != "":
+		// Gitea also sends X-GitHub-Event, hence it must be checked first
+		if err := m.verifyHMAC(body, h.Get("X-Gitea-Signature"), ""); err != nil {
+			return nil, monitorAuthError{fmt.Errorf("gitea: %v", err)}
+		}
+		if event := h.Get("X-Gitea-Event"); event != "push" {
+			log.Printf("monitor: gitea: ignoring event: %s", event)
+			return nil, nil
+		}
+		return commitPaths(body)
+
+	case h.Get("X-GitHub-Event") != "":
+		if err := m.verifyHMAC(body, h.Get("X-Hub-Signature-256"), "sha256="); err != nil {
+			return nil, monitorAuthError{fmt.Errorf("github: %v", err)}
+		}
+		if event := h.Get("X-GitHub-Event"); event != "push" {
+			log.Printf("monitor: github: ignoring event: %s", event)
+			return nil, nil
+		}
+		return commitPaths(body)
+
+	case h.Get("X-Gitlab-Event") != "":
+		if err := m.verifyToken(h.Get("X-Gitlab-Token")); err != nil {
+			return nil, monitorAuthError{fmt.Errorf("gitlab: %v", err)}
+		}
+		if event := h.Get("X-Gitlab-Event"); event != "Push Hook" {
+			log.Printf("monitor: gitlab: ignoring event: %s", event)
+			return nil, nil
+		}
+		return commitPaths(body)
+
+	case h.Get("X-Event-Key") != "":
+		if err := m.verifyHMAC(body, h.Get("X-Hub-Signature"), "sha256="); err != nil {
+			return nil, monitorAuthError{fmt.Errorf("bitbucket: %v", err)}
+		}
+		switch event := h.Get("X-Event-Key"); event {
+		case "repo:push", "repo:refs_changed":
+		default:
+			log.Printf("monitor: bitbucket: ignoring event: %s", event)
+			return nil, nil
+		}
+		// bitbucket payload does not list modified files, hence refresh everything
+		return []string{"application.yml"}, nil
+	}
+
+	// generic request with form parameter path
+	if err := m.verifyToken(r.URL.Query().Get("token")); err != nil {
+		return nil, monitorAuthError{fmt.Errorf("generic: %v", err)}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("generic: parse form: %v", err)
+	}
+	return r.Form["path"], nil
+}
+
+// verifyHMAC verifies HMAC-SHA256 signature in hex, with optional prefix like "sha256=".
+func (m *monitorHandler) verifyHMAC(body []byte, signature, prefix string) error {
+	sig, found := strings.CutPrefix(signature, prefix)
+	if !found {
+		return errors.New("missing signature")
+	}
+	received, errHex := hex.DecodeString(sig)
+	if errHex != nil {
+		return fmt.Errorf("bad signature: %v", errHex)
+	}
+	mac := hmac.New(sha256.New, []byte(m.secret))
+	mac.Write(body)
+	if !hmac.Equal(received, mac.Sum(nil)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// verifyToken verifies plain shared secret.
+func (m *monitorHandler) verifyToken(token string) error {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(m.secret)) != 1 {
+		return errors.New("token mismatch")
+	}
+	return nil
+}
+
+// pushPayload is the subset of GitHub, GitLab and Gitea push payloads we need.
+type pushPayload struct {
+	Commits []struct {
+		Added    []string `json:"added"`
+		Modified []string `json:"modified"`
+		Removed  []string `json:"removed"`
+	} `json:"commits"`
+}
+
+func commitPaths(body []byte) ([]string, error) {
+	var payload pushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("decode push payload: %v", err)
+	}
+	var paths []string
+	for _, c := range payload.Commits {
+		paths = append(paths, c.Added...)
+		paths = append(paths, c.Modified...)
+		paths = append(paths, c.Removed...)
+	}
+	return paths, nil
+}
+
+// guessDestinations guesses refresh destinations from file paths,
+// like Spring Cloud Config Monitor does.
+//
+// Example: "config/myapp-dev.yml" -> "myapp:dev:**", "myapp-dev:**"
+//
+// Files named application* affect all applications: "application.yml" -> "*:**"
+func guessDestinations(paths []string) []string {
+	var destinations []string
+	seen := map[string]bool{}
+	add := func(d string) {
+		d += ":**"
+		if !seen[d] {
+			seen[d] = true
+			destinations = append(destinations, d)
+		}
+	}
+	for _, p := range paths {
+		base := path.Base(p)
+		stem := strings.TrimSuffix(base, path.Ext(base))
+		if stem == "" || stem == "." || stem == "/" {
+			continue
+		}
+		for i := strings.Index(stem, "-"); i >= 0; i = nextDash(stem, i) {
+			name, profile := stem[:i], stem[i+1:]
+			switch {
+			case name == "application":
+				add("*:" + profile)
+			case !strings.HasPrefix(name, "application"):
+				add(name + ":" + profile)
+			}
+		}
+		switch {
+		case stem == "application":
+			add("*")
+		case !strings.HasPrefix(stem, "application"):
+			add(stem)
+		}
+	}
+	return destinations
+}
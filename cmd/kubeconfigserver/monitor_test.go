@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGuessDestinations(t *testing.T) {
+	for _, data := range []struct {
+		paths    []string
+		expected []string
+	}{
+		{[]string{"myapp.yml"}, []string{"myapp:**"}},
+		{[]string{"config/myapp-dev.yml"}, []string{"myapp:dev:**", "myapp-dev:**"}},
+		{[]string{"config-file2-default.yml"}, []string{"config:file2-default:**", "config-file2:default:**", "config-file2-default:**"}},
+		{[]string{"application.yml"}, []string{"*:**"}},
+		{[]string{"application-dev.yml"}, []string{"*:dev:**"}},
+		{[]string{"a.yml", "a.properties"}, []string{"a:**"}},
+		{nil, nil},
+	} {
+		result := guessDestinations(data.paths)
+		if !reflect.DeepEqual(result, data.expected) {
+			t.Errorf("paths=%v expected=%v got=%v", data.paths, data.expected, result)
+		}
+	}
+}
+
+const testPushPayload = `{"commits":[{"added":["new-dev.yml"],"modified":["config/myapp.yml"],"removed":[]}]}`
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestMonitorExtractPaths(t *testing.T) {
+	const secret = "s3cr3t"
+	m := &monitorHandler{secret: secret}
+
+	for _, data := range []struct {
+		name      string
+		headers   map[string]string
+		expectErr bool
+		expected  []string
+	}{
+		{"github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(secret, testPushPayload)}, false, []string{"new-dev.yml", "config/myapp.yml"}},
+		{"github bad signature", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign("wrong", testPushPayload)}, true, nil},
+		{"github ping", map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": "sha256=" + sign(secret, testPushPayload)}, false, nil},
+		{"gitea", map[string]string{"X-Gitea-Event": "push", "X-GitHub-Event": "push", "X-Gitea-Signature": sign(secret, testPushPayload)}, false, []string{"new-dev.yml", "config/myapp.yml"}},
+		{"gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": secret}, false, []string{"new-dev.yml", "config/myapp.yml"}},
+		{"gitlab bad token", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}, true, nil},
+		{"bitbucket", map[string]string{"X-Event-Key": "repo:push", "X-Hub-Signature": "sha256=" + sign(secret, testPushPayload)}, false, []string{"application.yml"}},
+		{"bitbucket missing signature", map[string]string{"X-Event-Key": "repo:push"}, true, nil},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/monitor", strings.NewReader(testPushPayload))
+		for k, v := range data.headers {
+			req.Header.Set(k, v)
+		}
+		paths, err := m.extractPaths(req, []byte(testPushPayload))
+		if (err != nil) != data.expectErr {
+			t.Errorf("%s: expectErr=%t got error: %v", data.name, data.expectErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(paths, data.expected) {
+			t.Errorf("%s: expected=%v got=%v", data.name, data.expected, paths)
+		}
+	}
+}
+
+func TestMonitorHandleErrors(t *testing.T) {
+	const secret = "s3cr3t"
+	m := newMonitorHandler(nil, secret)
+
+	router := gin.New()
+	router.POST("/monitor", m.handle)
+
+	for _, data := range []struct {
+		name    string
+		headers map[string]string
+		body    string
+		status  int
+	}{
+		{"bad signature", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign("wrong", testPushPayload)}, testPushPayload, http.StatusUnauthorized},
+		{"bad token", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}, testPushPayload, http.StatusUnauthorized},
+		{"bad payload", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": secret}, "{", http.StatusBadRequest},
+		{"bad signed payload", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(secret, "[")}, "[", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/monitor", bytes.NewBufferString(data.body))
+		for k, v := range data.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != data.status {
+			t.Errorf("%s: expected status %d, got %d", data.name, data.status, w.Code)
+		}
+	}
+}
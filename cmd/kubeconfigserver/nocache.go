@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mailgun/groupcache/singleflight"
+)
+
+// coalescer is used in place of groupcache when cache is disabled (CACHE=false).
+// Concurrent requests for the same path are deduplicated into a single
+// backend fetch, and the result is kept in a tiny local cache for a short TTL.
+type coalescer struct {
+	storage backend
+	ttl     time.Duration // zero disables the local cache
+	flight  singleflight.Group
+	mutex   sync.Mutex
+	entries map[string]localEntry
+}
+
+type localEntry struct {
+	data   []byte
+	expire time.Time
+}
+
+func newCoalescer(storage backend, ttl time.Duration) *coalescer {
+	return &coalescer{
+		storage: storage,
+		ttl:     ttl,
+		entries: map[string]localEntry{},
+	}
+}
+
+func (c *coalescer) fetch(ctx context.Context, path string) ([]byte, error) {
+	if data, found := c.get(path); found {
+		return data, nil
+	}
+
+	// do not let a single canceled client abort the fetch shared with other clients
+	ctx = context.WithoutCancel(ctx)
+
+	value, err := c.flight.Do(path, func() (interface{}, error) {
+		data, errFetch := fetch(ctx, c.storage, path)
+		if errFetch != nil {
+			return nil, errFetch
+		}
+		c.put(path, data)
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]byte), nil
+}
+
+func (c *coalescer) get(path string) ([]byte, bool) {
+	if c.ttl == 0 {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, found := c.entries[path]
+	if !found || time.Now().After(e.expire) {
+		return nil, false
+	}
+	return e.data, true
+}
+
+func (c *coalescer) put(path string, data []byte) {
+	if c.ttl == 0 {
+		return
+	}
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for k, e := range c.entries {
+		if now.After(e.expire) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[path] = localEntry{data: data, expire: now.Add(c.ttl)}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type backendCount struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (b *backendCount) fetch(_ context.Context, path string) ([]byte, error) {
+	b.calls.Add(1)
+	<-b.release
+	return []byte(path), nil
+}
+
+func TestCoalescer(t *testing.T) {
+	storage := &backendCount{release: make(chan struct{})}
+	c := newCoalescer(storage, time.Minute)
+
+	const clients = 10
+	var wg sync.WaitGroup
+	wg.Add(clients)
+	for i := 0; i < clients; i++ {
+		go func() {
+			defer wg.Done()
+			data, err := c.fetch(context.Background(), "/app-default.yml")
+			if err != nil || string(data) != "/app-default.yml" {
+				t.Errorf("unexpected result: data='%s' error: %v", string(data), err)
+			}
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond) // let clients pile up
+	close(storage.release)
+	wg.Wait()
+
+	if _, err := c.fetch(context.Background(), "/app-default.yml"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if calls := storage.calls.Load(); calls != 1 {
+		t.Errorf("expected 1 backend call, got %d", calls)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/udhos/kubegroup/kubegroup"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+/*
+Groupcache peer discovery, selected by PEER_DISCOVERY:
+
+- kubernetes: ready pods sharing the label app of this pod are listed and watched (requires pod get/list/watch permission).
+- static: GROUPCACHE_PEERS sets a fixed list of peer URLs, like http://10.0.0.1:5000,http://10.0.0.2:5000
+- dns: GROUPCACHE_PEERS_DNS names a headless service, whose A/AAAA addresses become peers at GROUPCACHE_PORT.
+- dns-srv: GROUPCACHE_PEERS_DNS names a SRV record, like _groupcache._tcp.kubeconfigserver-peers.develop.svc.cluster.local,
+  whose targets become peers at the port given by the record.
+
+DNS names are resolved every GROUPCACHE_PEERS_DNS_INTERVAL, which should be
+shorter than SHUTDOWN_DRAIN, so that peers drop a terminating pod before it stops.
+
+The default is kubernetes, or with STANDALONE=true, dns when GROUPCACHE_PEERS_DNS is set, else static.
+STANDALONE=true also skips Kubernetes entirely, so the server runs on a laptop, VM or plain container.
+Static discovery without peers makes the server its own single peer.
+
+GROUPCACHE_MY_URL overrides the URL of this server as seen by peers.
+*/
+
+// peerSetter updates groupcache peers, like *groupcache.HTTPPool.
+type peerSetter interface {
+	Set(peers ...string)
+}
+
+// peerDiscovery keeps groupcache peers up to date.
+type peerDiscovery interface {
+	run(ctx context.Context)
+}
+
+// defaultPeerDiscovery picks discovery method when PEER_DISCOVERY is unset.
+func defaultPeerDiscovery(standalone bool, peersDNS string) string {
+	switch {
+	case !standalone:
+		return "kubernetes"
+	case peersDNS != "":
+		return "dns"
+	}
+	return "static"
+}
+
+// newPeerDiscovery creates discovery for PEER_DISCOVERY.
+// Discovered peers are set into the pool through status, to record membership.
+func newPeerDiscovery(config appConfig, status *peerStatus, myURL string) (peerDiscovery, error) {
+	switch config.peerDiscovery {
+	case "kubernetes":
+		if config.standalone {
+			return nil, fmt.Errorf("PEER_DISCOVERY=kubernetes conflicts with STANDALONE=true")
+		}
+		return &kubePeers{pool: status, myURL: myURL, groupcachePort: config.groupcachePort}, nil
+	case "static":
+		return &staticPeers{pool: status, peers: peerList(myURL, config.groupcachePeers)}, nil
+	case "dns", "dns-srv":
+		name := config.groupcachePeersDNS
+		if name == "" {
+			return nil, fmt.Errorf("PEER_DISCOVERY=%s requires GROUPCACHE_PEERS_DNS", config.peerDiscovery)
+		}
+		if config.groupcachePeersDNSInterval <= 0 {
+			return nil, fmt.Errorf("GROUPCACHE_PEERS_DNS_INTERVAL=%v must be positive", config.groupcachePeersDNSInterval)
+		}
+		if config.shutdownDrain < config.groupcachePeersDNSInterval {
+			log.Printf("dnsPeers: WARNING: SHUTDOWN_DRAIN=%v is shorter than GROUPCACHE_PEERS_DNS_INTERVAL=%v, peers may still route keys to a stopped pod",
+				config.shutdownDrain, config.groupcachePeersDNSInterval)
+		}
+		lookup := lookupA(name, config.groupcachePort)
+		if config.peerDiscovery == "dns-srv" {
+			lookup = lookupSRV(name)
+		}
+		return &dnsPeers{
+			pool:     status,
+			myURL:    myURL,
+			name:     name,
+			interval: config.groupcachePeersDNSInterval,
+			lookup:   lookup,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown PEER_DISCOVERY=%q, expecting one of: kubernetes, static, dns, dns-srv",
+		config.peerDiscovery)
+}
+
+// peerURL builds groupcache URL for peer address.
+// groupcachePort example: ":5000".
+func peerURL(addr, groupcachePort string) string {
+	_, port, errSplit := net.SplitHostPort(groupcachePort)
+	if errSplit != nil {
+		port = groupcachePort
+	}
+	return "http://" + net.JoinHostPort(addr, port)
+}
+
+// findMyURL finds URL of this server for the groupcache pool.
+// It retries until the address is found, except in standalone mode,
+// which falls back to the loopback address.
+func findMyURL(config appConfig) string {
+	if config.groupcacheMyURL != "" {
+		return config.groupcacheMyURL
+	}
+
+	for {
+		myURL, errURL := kubegroup.FindMyURL(config.groupcachePort)
+		if errURL == nil && myURL != "" {
+			return myURL
+		}
+		log.Printf("my URL: %v", errURL)
+		if config.standalone {
+			myURL = peerURL("127.0.0.1", config.groupcachePort)
+			log.Printf("my URL: standalone: using %s", myURL)
+			return myURL
+		}
+		const cooldown = 5 * time.Second
+		log.Printf("could not find my URL, sleeping %v", cooldown)
+		time.Sleep(cooldown)
+	}
+}
+
+// peerList adds myURL to peers, removing duplicates, in stable order.
+func peerList(myURL string, peers []string) []string {
+	list := append([]string{myURL}, peers...)
+	slices.Sort(list)
+	return slices.Compact(list)
+}
+
+// kubePeersRetry is how long kubernetes discovery waits before retrying after errors.
+const kubePeersRetry = 5 * time.Second
+
+// podWatcher lists and watches pods, like the client-go PodInterface.
+type podWatcher interface {
+	List(ctx context.Context, opts metav1.ListOptions) (*corev1.PodList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+}
+
+// kubePeers discovers peers from kubernetes pods.
+type kubePeers struct {
+	pool           peerSetter
+	myURL          string
+	groupcachePort string
+
+	// connect finds pods to watch and their label selector.
+	// Optional, defaults to kubePods.
+	connect func(ctx context.Context) (podWatcher, string, error)
+}
+
+// run keeps peers up to date until ctx is done.
+// Errors are retried every kubePeersRetry, keeping the current peers.
+func (k *kubePeers) run(ctx context.Context) {
+	connect := k.connect
+	if connect == nil {
+		connect = kubePods
+	}
+
+	var pods podWatcher
+	var selector string
+
+	for {
+		var err error
+		if pods == nil {
+			pods, selector, err = connect(ctx)
+		}
+		if err == nil {
+			err = k.watch(ctx, pods, selector)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("kubePeers: %v, retrying in %v", err, kubePeersRetry)
+
+		select {
+		case <-time.After(kubePeersRetry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watch lists pods, then follows pod events, updating peers on every change.
+// It returns when the watch ends.
+func (k *kubePeers) watch(ctx context.Context, pods podWatcher, selector string) error {
+	list, errList := pods.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if errList != nil {
+		return fmt.Errorf("list pods %s: %w", selector, errList)
+	}
+
+	table := map[string]string{} // pod name => peer URL
+	for i := range list.Items {
+		k.update(table, &list.Items[i], false)
+	}
+	var current []string
+	current = k.set(table, current)
+
+	w, errWatch := pods.Watch(ctx, metav1.ListOptions{
+		LabelSelector:   selector,
+		ResourceVersion: list.ResourceVersion,
+	})
+	if errWatch != nil {
+		return fmt.Errorf("watch pods %s: %w", selector, errWatch)
+	}
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return fmt.Errorf("watch pods %s: closed", selector)
+			}
+			if event.Type == watch.Error {
+				return fmt.Errorf("watch pods %s: %v", selector, event.Object)
+			}
+			pod, isPod := event.Object.(*corev1.Pod)
+			if !isPod {
+				continue
+			}
+			k.update(table, pod, event.Type == watch.Deleted)
+			current = k.set(table, current)
+		}
+	}
+}
+
+// update records peer URL for ready pod, removing pods deleted or not ready.
+func (k *kubePeers) update(table map[string]string, pod *corev1.Pod, deleted bool) {
+	if deleted || !isPodReady(pod) || pod.Status.PodIP == "" {
+		delete(table, pod.Name)
+		return
+	}
+	table[pod.Name] = peerURL(pod.Status.PodIP, k.groupcachePort)
+}
+
+// set updates pool when peers differ from current, returning peers.
+func (k *kubePeers) set(table map[string]string, current []string) []string {
+	var urls []string
+	for _, u := range table {
+		urls = append(urls, u)
+	}
+	peers := peerList(k.myURL, urls)
+	if slices.Equal(peers, current) {
+		return current
+	}
+	log.Printf("kubePeers: updating peers: %v", peers)
+	k.pool.Set(peers...)
+	return peers
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// kubePods finds this pod, then selects pods in its namespace sharing its label app.
+func kubePods(ctx context.Context) (podWatcher, string, error) {
+	config, errConfig := rest.InClusterConfig()
+	if errConfig != nil {
+		return nil, "", errConfig
+	}
+	clientset, errClient := kubernetes.NewForConfig(config)
+	if errClient != nil {
+		return nil, "", errClient
+	}
+	namespace, errNamespace := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if errNamespace != nil {
+		return nil, "", errNamespace
+	}
+	pods := clientset.CoreV1().Pods(strings.TrimSpace(string(namespace)))
+	name := hostname()
+	me, errPod := pods.Get(ctx, name, metav1.GetOptions{})
+	if errPod != nil {
+		return nil, "", fmt.Errorf("get my pod %s: %w", name, errPod)
+	}
+	selector := "app=" + me.Labels["app"]
+	log.Printf("kubePeers: namespace=%s selector=%s", me.Namespace, selector)
+	return pods, selector, nil
+}
+
+// staticPeers sets fixed list of peers.
+type staticPeers struct {
+	pool  peerSetter
+	peers []string
+}
+
+func (s *staticPeers) run(_ context.Context) {
+	log.Printf("staticPeers: peers: %v", s.peers)
+	s.pool.Set(s.peers...)
+}
+
+// dnsPeers periodically resolves peers from DNS.
+type dnsPeers struct {
+	pool     peerSetter
+	myURL    string
+	name     string
+	interval time.Duration
+	lookup   func(ctx context.Context) ([]string, error) // returns peer URLs
+}
+
+// run keeps peers up to date until ctx is done.
+// Lookup errors keep the current peers.
+func (d *dnsPeers) run(ctx context.Context) {
+	var current []string
+
+	for {
+		peers, errLookup := d.lookup(ctx)
+		if errLookup != nil {
+			log.Printf("dnsPeers: lookup %s: %v", d.name, errLookup)
+		} else {
+			if !slices.Contains(peers, d.myURL) {
+				// another URL for ourselves would be taken as remote peer
+				log.Printf("dnsPeers: WARNING: my URL %s not found in %s peers %v, consider GROUPCACHE_MY_URL",
+					d.myURL, d.name, peers)
+			}
+			peers = peerList(d.myURL, peers)
+			if !slices.Equal(peers, current) {
+				log.Printf("dnsPeers: name=%s updating peers: %v", d.name, peers)
+				d.pool.Set(peers...)
+				current = peers
+			}
+		}
+
+		select {
+		case <-time.After(d.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lookupA resolves name addresses into peer URLs at groupcachePort.
+func lookupA(name, groupcachePort string) func(ctx context.Context) ([]string, error) {
+	return func(ctx context.Context) ([]string, error) {
+		addrs, errLookup := net.DefaultResolver.LookupHost(ctx, name)
+		if errLookup != nil {
+			return nil, errLookup
+		}
+		var peers []string
+		for _, addr := range addrs {
+			peers = append(peers, peerURL(addr, groupcachePort))
+		}
+		return peers, nil
+	}
+}
+
+// lookupSRV resolves SRV record name into peer URLs.
+// Targets are resolved into addresses, in order to match my URL.
+func lookupSRV(name string) func(ctx context.Context) ([]string, error) {
+	return func(ctx context.Context) ([]string, error) {
+		_, records, errLookup := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+		if errLookup != nil {
+			return nil, errLookup
+		}
+		var peers []string
+		for _, srv := range records {
+			target := strings.TrimSuffix(srv.Target, ".")
+			addrs, errHost := net.DefaultResolver.LookupHost(ctx, target)
+			if errHost != nil {
+				log.Printf("lookupSRV: %s: target %s: %v", name, target, errHost)
+				continue
+			}
+			port := strconv.Itoa(int(srv.Port))
+			for _, addr := range addrs {
+				peers = append(peers, "http://"+net.JoinHostPort(addr, port))
+			}
+		}
+		return peers, nil
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestPeerURL(t *testing.T) {
+	for _, data := range []struct {
+		addr     string
+		port     string
+		expected string
+	}{
+		{"10.0.0.1", ":5000", "http://10.0.0.1:5000"},
+		{"10.0.0.1", "0.0.0.0:5000", "http://10.0.0.1:5000"},
+		{"fd00::1", ":5000", "http://[fd00::1]:5000"},
+		{"10.0.0.1", "5000", "http://10.0.0.1:5000"},
+	} {
+		if result := peerURL(data.addr, data.port); result != data.expected {
+			t.Errorf("addr=%s port=%s expected=%s got=%s", data.addr, data.port, data.expected, result)
+		}
+	}
+}
+
+func TestPeerList(t *testing.T) {
+	result := peerList("http://b:5000", []string{"http://c:5000", "http://a:5000", "http://b:5000", "http://c:5000"})
+	expected := []string{"http://a:5000", "http://b:5000", "http://c:5000"}
+	if !slices.Equal(result, expected) {
+		t.Errorf("expected=%v got=%v", expected, result)
+	}
+}
+
+type fakePeerSetter struct {
+	peers chan []string
+}
+
+func (f *fakePeerSetter) Set(peers ...string) {
+	f.peers <- peers
+}
+
+func TestDNSPeers(t *testing.T) {
+	pool := &fakePeerSetter{peers: make(chan []string, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	const myURL = "http://10.0.0.1:5000"
+
+	results := make(chan []string, 3)
+	results <- []string{"http://10.0.0.2:5000", myURL}
+	results <- nil // lookup error keeps peers
+	results <- []string{myURL, "http://10.0.0.2:5000"}
+
+	d := &dnsPeers{
+		pool:     pool,
+		myURL:    myURL,
+		name:     "test",
+		interval: time.Millisecond,
+		lookup: func(_ context.Context) ([]string, error) {
+			select {
+			case peers := <-results:
+				if peers == nil {
+					return nil, errors.New("lookup failed")
+				}
+				return peers, nil
+			default:
+				return []string{"http://10.0.0.3:5000"}, nil
+			}
+		},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.run(ctx)
+		close(done)
+	}()
+
+	for _, expected := range [][]string{
+		{"http://10.0.0.1:5000", "http://10.0.0.2:5000"},
+		{"http://10.0.0.1:5000", "http://10.0.0.3:5000"}, // unchanged lists not set again
+	} {
+		select {
+		case peers := <-pool.peers:
+			if !slices.Equal(peers, expected) {
+				t.Errorf("expected=%v got=%v", expected, peers)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("peers not updated, expected %v", expected)
+		}
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("not stopped by context")
+	}
+}
+
+type fakePods struct {
+	list    *corev1.PodList
+	watcher *watch.FakeWatcher
+	opts    chan metav1.ListOptions
+}
+
+func (f *fakePods) List(_ context.Context, _ metav1.ListOptions) (*corev1.PodList, error) {
+	return f.list, nil
+}
+
+func (f *fakePods) Watch(_ context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	f.opts <- opts
+	return f.watcher, nil
+}
+
+func testPod(name, ip string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.PodStatus{
+			PodIP:      ip,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestKubePeers(t *testing.T) {
+	pool := &fakePeerSetter{peers: make(chan []string, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pods := &fakePods{
+		list: &corev1.PodList{
+			ListMeta: metav1.ListMeta{ResourceVersion: "42"},
+			Items: []corev1.Pod{
+				*testPod("me", "10.0.0.1", true),
+				*testPod("ready", "10.0.0.2", true),
+				*testPod("starting", "10.0.0.3", false),
+			},
+		},
+		watcher: watch.NewFake(),
+		opts:    make(chan metav1.ListOptions, 1),
+	}
+
+	k := &kubePeers{
+		pool:           pool,
+		myURL:          "http://10.0.0.1:5000",
+		groupcachePort: ":5000",
+		connect: func(context.Context) (podWatcher, string, error) {
+			return pods, "app=kubeconfigserver", nil
+		},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		k.run(ctx)
+		close(done)
+	}()
+
+	expect := func(expected ...string) {
+		t.Helper()
+		select {
+		case peers := <-pool.peers:
+			if !slices.Equal(peers, expected) {
+				t.Errorf("expected=%v got=%v", expected, peers)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("peers not updated, expected %v", expected)
+		}
+	}
+
+	expect("http://10.0.0.1:5000", "http://10.0.0.2:5000")
+
+	if opts := <-pods.opts; opts.LabelSelector != "app=kubeconfigserver" || opts.ResourceVersion != "42" {
+		t.Errorf("unexpected watch options: %+v", opts)
+	}
+
+	pods.watcher.Modify(testPod("starting", "10.0.0.3", true))
+	expect("http://10.0.0.1:5000", "http://10.0.0.2:5000", "http://10.0.0.3:5000")
+
+	pods.watcher.Modify(testPod("ready", "10.0.0.2", true)) // unchanged peers not set again
+	pods.watcher.Delete(testPod("starting", "", false))     // deleted pods may lack address
+	expect("http://10.0.0.1:5000", "http://10.0.0.2:5000")
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("not stopped by context")
+	}
+}
+
+func TestLookupA(t *testing.T) {
+	peers, err := lookupA("localhost", ":5000")(context.Background())
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if !slices.Contains(peers, "http://127.0.0.1:5000") {
+		t.Errorf("unexpected peers: %v", peers)
+	}
+}
+
+func TestNewPeerDiscovery(t *testing.T) {
+	for _, data := range []struct {
+		config appConfig
+		fail   bool
+	}{
+		{appConfig{peerDiscovery: defaultPeerDiscovery(false, "")}, false},
+		{appConfig{peerDiscovery: defaultPeerDiscovery(true, ""), standalone: true}, false},
+		{appConfig{peerDiscovery: defaultPeerDiscovery(true, "peers"), standalone: true, groupcachePeersDNS: "peers", groupcachePeersDNSInterval: time.Second}, false},
+		{appConfig{peerDiscovery: "dns-srv", groupcachePeersDNS: "_groupcache._tcp.peers", groupcachePeersDNSInterval: time.Second}, false},
+		{appConfig{peerDiscovery: "dns", groupcachePeersDNS: "peers"}, true},
+		{appConfig{peerDiscovery: "dns"}, true},
+		{appConfig{peerDiscovery: "kubernetes", standalone: true}, true},
+		{appConfig{peerDiscovery: "consul"}, true},
+	} {
+		_, err := newPeerDiscovery(data.config, nil, "http://10.0.0.1:5000")
+		if data.fail != (err != nil) {
+			t.Errorf("discovery=%s standalone=%t: expected fail=%t, got error: %v",
+				data.config.peerDiscovery, data.config.standalone, data.fail, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mailgun/groupcache"
+)
+
+/*
+Groupcache peer status, for debugging uneven load or split-brain caches:
+
+curl -H "Authorization: Bearer $ADMIN_TOKEN" localhost:8888/admin/peers
+
+Membership (peer list, count and last change) is recorded as discovery updates
+the pool.
+
+Requests to peers are counted per peer by the pool transport.
+Counters of departed peers are dropped, in order to bound metric cardinality.
+*/
+
+type peerCounters struct {
+	requests int64
+	errors   int64
+}
+
+// peerStatus tracks groupcache peer membership and requests sent to peers.
+type peerStatus struct {
+	pool      peerSetter
+	myURL     string
+	discovery string
+
+	mutex   sync.Mutex
+	peers   []string // nil when unknown
+	count   int
+	changed time.Time
+	stats   map[string]*peerCounters // peer URL => counters
+}
+
+func newPeerStatus(pool peerSetter, myURL, discovery string) *peerStatus {
+	return &peerStatus{
+		pool:      pool,
+		myURL:     myURL,
+		discovery: discovery,
+		stats:     map[string]*peerCounters{},
+	}
+}
+
+// Set updates pool peers, recording membership.
+func (s *peerStatus) Set(peers ...string) {
+	s.pool.Set(peers...)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.change(peers)
+}
+
+// change records membership change. It must be called with mutex held.
+func (s *peerStatus) change(peers []string) {
+	for p := range s.stats {
+		if !slices.Contains(peers, p) {
+			// forget departed peer
+			delete(s.stats, p)
+			peerRequests.DeleteLabelValues(p)
+			peerErrors.DeleteLabelValues(p)
+		}
+	}
+
+	s.peers = slices.Clone(peers)
+	s.count = len(peers)
+	s.changed = time.Now()
+	peersGauge.Set(float64(s.count))
+	peersChanged.Set(float64(s.changed.Unix()))
+}
+
+// transport counts requests to peers, for use as HTTPPool.Transport.
+// Headers attached to ctx by withPeerHeader are added to the request.
+func (s *peerStatus) transport(ctx groupcache.Context) http.RoundTripper {
+	t := &peerTransport{status: s, base: http.DefaultTransport}
+	if c, isContext := ctx.(context.Context); isContext {
+		t.header, _ = c.Value(peerHeaderKey{}).(http.Header)
+	}
+	return t
+}
+
+type peerHeaderKey struct{}
+
+// withPeerHeader attaches header to requests sent to peers under ctx.
+func withPeerHeader(ctx context.Context, key, value string) context.Context {
+	h, _ := ctx.Value(peerHeaderKey{}).(http.Header)
+	h = h.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Set(key, value)
+	return context.WithValue(ctx, peerHeaderKey{}, h)
+}
+
+func (s *peerStatus) countRequest(peer string, failed bool) {
+	peerRequests.WithLabelValues(peer).Inc()
+	if failed {
+		peerErrors.WithLabelValues(peer).Inc()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, found := s.stats[peer]
+	if !found {
+		c = &peerCounters{}
+		s.stats[peer] = c
+	}
+	c.requests++
+	if failed {
+		c.errors++
+	}
+}
+
+type peerTransport struct {
+	status *peerStatus
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t *peerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.header) > 0 {
+		req = req.Clone(req.Context())
+		for k, v := range t.header {
+			req.Header[k] = v
+		}
+	}
+	peer := req.URL.Scheme + "://" + req.URL.Host
+	resp, err := t.base.RoundTrip(req)
+	t.status.countRequest(peer, err != nil || resp.StatusCode != http.StatusOK)
+	return resp, err
+}
+
+type peerStat struct {
+	Peer     string `json:"peer"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"`
+}
+
+type peerReport struct {
+	MyURL      string     `json:"my_url"`
+	Discovery  string     `json:"discovery"`
+	Peers      []string   `json:"peers,omitempty"`
+	PeerCount  int        `json:"peer_count"`
+	LastChange time.Time  `json:"last_change"`
+	Stats      []peerStat `json:"stats"`
+}
+
+func (s *peerStatus) report() peerReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r := peerReport{
+		MyURL:      s.myURL,
+		Discovery:  s.discovery,
+		Peers:      slices.Clone(s.peers),
+		PeerCount:  s.count,
+		LastChange: s.changed,
+		Stats:      []peerStat{},
+	}
+	for peer, c := range s.stats {
+		r.Stats = append(r.Stats, peerStat{Peer: peer, Requests: c.requests, Errors: c.errors})
+	}
+	sort.Slice(r.Stats, func(i, j int) bool { return r.Stats[i].Peer < r.Stats[j].Peer })
+	return r
+}
+
+func (s *peerStatus) handle(c *gin.Context) {
+	c.JSON(http.StatusOK, s.report())
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type recordPeerSetter struct {
+	peers []string
+}
+
+func (r *recordPeerSetter) Set(peers ...string) {
+	r.peers = peers
+}
+
+func TestPeerStatus(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer peer.Close()
+
+	pool := &recordPeerSetter{}
+	s := newPeerStatus(pool, "http://10.0.0.1:5000", "static")
+
+	s.Set("http://10.0.0.1:5000", peer.URL)
+	if !slices.Equal(pool.peers, []string{"http://10.0.0.1:5000", peer.URL}) {
+		t.Errorf("pool not updated: %v", pool.peers)
+	}
+
+	client := http.Client{Transport: s.transport(context.Background())}
+	for _, path := range []string{"/ok", "/ok", "/missing"} {
+		resp, err := client.Get(peer.URL + path)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	router := gin.New()
+	router.GET("/admin/peers", s.handle)
+
+	get := func() peerReport {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/peers", nil))
+		var r peerReport
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+			t.Fatalf("json: %v: %s", err, w.Body.String())
+		}
+		return r
+	}
+
+	r := get()
+	if r.MyURL != "http://10.0.0.1:5000" || r.Discovery != "static" || r.PeerCount != 2 || len(r.Peers) != 2 {
+		t.Errorf("unexpected report: %+v", r)
+	}
+	if r.LastChange.IsZero() {
+		t.Errorf("missing last change")
+	}
+	if len(r.Stats) != 1 || r.Stats[0] != (peerStat{Peer: peer.URL, Requests: 3, Errors: 1}) {
+		t.Errorf("unexpected stats: %+v", r.Stats)
+	}
+
+	// departed peer is forgotten
+	s.Set("http://10.0.0.1:5000")
+	if r = get(); r.PeerCount != 1 || len(r.Stats) != 0 {
+		t.Errorf("unexpected report after departure: %+v", r)
+	}
+}
+
+func TestPeerTransportHeader(t *testing.T) {
+	var received string
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("X-Test")
+	}))
+	defer peer.Close()
+
+	s := newPeerStatus(&recordPeerSetter{}, "http://10.0.0.1:5000", "static")
+
+	for _, data := range []struct {
+		ctx      context.Context
+		expected string
+	}{
+		{context.Background(), ""},
+		{withPeerHeader(context.Background(), "X-Test", "value"), "value"},
+	} {
+		client := http.Client{Transport: s.transport(data.ctx)}
+		resp, err := client.Get(peer.URL)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		resp.Body.Close()
+		if received != data.expected {
+			t.Errorf("expected header %q, got %q", data.expected, received)
+		}
+	}
+}
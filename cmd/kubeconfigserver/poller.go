@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mailgun/groupcache"
+)
+
+/*
+Backend change polling, for backends that can't push notifications.
+
+Whenever a key is loaded from the backend, the content hash is recorded.
+Every POLL_INTERVAL, each replica re-fetches the keys it owns (according to
+groupcache consistent hashing) and compares the content hashes. Only keys
+whose content changed are invalidated, re-fetched and re-published.
+Since only the owner polls a key, the event is re-published once.
+*/
+
+type pollEntry struct {
+	storage backend
+	hash    [sha256.Size]byte
+}
+
+type poller struct {
+	inv      *invalidator
+	picker   groupcache.PeerPicker
+	interval time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]pollEntry
+}
+
+func newPoller(inv *invalidator, picker groupcache.PeerPicker, interval time.Duration) *poller {
+	p := &poller{
+		inv:      inv,
+		picker:   picker,
+		interval: interval,
+		entries:  map[string]pollEntry{},
+	}
+	inv.groups.onLoad = p.record
+	return p
+}
+
+// record saves content hash for key loaded from backend.
+func (p *poller) record(key string, storage backend, data []byte) {
+	p.mutex.Lock()
+	p.entries[key] = pollEntry{storage: storage, hash: sha256.Sum256(data)}
+	p.mutex.Unlock()
+}
+
+func (p *poller) run() {
+	log.Printf("poll: interval=%v", p.interval)
+	for {
+		time.Sleep(p.interval)
+		p.poll(context.Background())
+	}
+}
+
+func (p *poller) poll(ctx context.Context) {
+	begin := time.Now()
+
+	p.mutex.Lock()
+	entries := make(map[string]pollEntry, len(p.entries))
+	for k, e := range p.entries {
+		entries[k] = e
+	}
+	p.mutex.Unlock()
+
+	var changed int
+
+	for key, e := range entries {
+		if _, remote := p.picker.PickPeer(key); remote {
+			// another peer owns the key now, let it poll the key
+			p.forget(key)
+			continue
+		}
+
+		data, errFetch := e.storage.fetch(ctx, key)
+		if errFetch != nil {
+			if errBackend, isBackend := errFetch.(backendError); !isBackend || errBackend.status != http.StatusNotFound {
+				log.Printf("poll: key='%s' fetch error: %v", key, errFetch)
+				pollErrors.Inc()
+				continue
+			}
+			// not found anymore: the cached content is stale
+		}
+
+		if errFetch == nil && sha256.Sum256(data) == e.hash {
+			continue // unchanged
+		}
+
+		changed++
+		pollChanges.Inc()
+
+		log.Printf("refresh: source=poll key='%s' content changed", key)
+
+		if removed := p.inv.removeKeys(ctx, "poll", key, []string{key}); len(removed) > 0 {
+			p.forget(key)
+			p.inv.refetch(ctx, key, removed)
+			for _, d := range keyApplications(key) {
+				p.inv.publish(ctx, d, removed)
+			}
+		}
+	}
+
+	log.Printf("poll: keys=%d changed=%d elapsed=%v", len(entries), changed, time.Since(begin))
+}
+
+func (p *poller) forget(key string) {
+	p.mutex.Lock()
+	delete(p.entries, key)
+	p.mutex.Unlock()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mailgun/groupcache"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPoller(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app-default.yml")
+	if err := os.WriteFile(file, []byte("a: 1\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	tracer := trace.NewNoopTracerProvider().Tracer("test")
+	storage := newBackendDir(tracer, dir, "", 0)
+	groups := &cacheGroups{}
+	groups.add("poller-test", 1<<20, "", storage)
+	keys := newTable()
+	events := &recordPublisher{}
+	inv := &invalidator{
+		groups:      groups,
+		keys:        keys,
+		republisher: &republisher{origin: "test", publishers: []publisher{events}},
+	}
+	p := newPoller(inv, groupcache.NoPeers{}, 0)
+
+	const key = "/app-default.yml"
+
+	get := func() string {
+		var data []byte
+		if err := groups.pick(key).group.Get(context.Background(), key, groupcache.AllocatingByteSliceSink(&data)); err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		_, payload, _ := decodeEntry(data)
+		keys.add(key)
+		return string(payload)
+	}
+
+	if data := get(); data != "a: 1\n" {
+		t.Fatalf("unexpected data: %q", data)
+	}
+
+	before := testutil.ToFloat64(pollChanges)
+
+	p.poll(context.Background())
+
+	if changes := testutil.ToFloat64(pollChanges) - before; changes != 0 {
+		t.Errorf("unchanged content: expected 0 changes, got %v", changes)
+	}
+
+	if err := os.WriteFile(file, []byte("a: 2\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	p.poll(context.Background())
+
+	if changes := testutil.ToFloat64(pollChanges) - before; changes != 1 {
+		t.Errorf("changed content: expected 1 change, got %v", changes)
+	}
+
+	if data := get(); data != "a: 2\n" {
+		t.Errorf("expected refreshed data, got: %q", data)
+	}
+
+	if len(events.events) != 1 || events.events[0].DestinationService != "app:**" {
+		t.Errorf("expected one event for app:**, got: %+v", events.events)
+	}
+}
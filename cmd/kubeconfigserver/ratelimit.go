@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+/*
+Rate limiting for the application server.
+
+RATE_LIMIT sets a token bucket of RATE_LIMIT requests per second (with burst RATE_BURST)
+for each source IP, checked before authentication so that failed logins are limited too,
+and another one for each authenticated identity, checked after authentication.
+
+The source IP is taken from X-Forwarded-For only for proxies listed in TRUSTED_PROXIES.
+
+MAX_IN_FLIGHT limits concurrent requests across all clients. Watch requests (long-poll
+and Server-Sent Events) are mostly idle, hence they are counted against their own
+limit MAX_WATCHES instead.
+
+Rejected requests get status 429 with header Retry-After.
+
+The gRPC server applies the same limits: the per-IP bucket to every call through
+interceptors, MAX_IN_FLIGHT to unary calls, MAX_WATCHES to Watch streams, and the
+per-identity bucket once the call is authenticated (see grpc.go).
+Rejected calls get code ResourceExhausted.
+*/
+
+// rateLimiterIdle is how long an idle client bucket is kept.
+const rateLimiterIdle = 5 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type rateLimits struct {
+	limit rate.Limit
+	burst int
+
+	mutex     sync.Mutex
+	clients   map[string]*clientLimiter
+	lastPrune time.Time
+
+	inFlight *concurrencyLimit
+	watches  *concurrencyLimit
+}
+
+func newRateLimits(limit float64, burst, maxInFlight, maxWatches int) *rateLimits {
+	r := &rateLimits{
+		limit:     rate.Limit(limit),
+		burst:     burst,
+		clients:   map[string]*clientLimiter{},
+		lastPrune: time.Now(),
+		inFlight:  newConcurrencyLimit(maxInFlight, inFlightRequests, "in_flight"),
+		watches:   newConcurrencyLimit(maxWatches, inFlightWatches, "watches"),
+	}
+	log.Printf("ratelimit: per-client rate=%v burst=%d max_in_flight=%d max_watches=%d", limit, burst, maxInFlight, maxWatches)
+	return r
+}
+
+// parseRate parses requests per second, like "10" or "0.5".
+func parseRate(s string) (float64, error) {
+	r, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if r < 0 || math.IsInf(r, 0) || math.IsNaN(r) {
+		return 0, strconv.ErrRange
+	}
+	return r, nil
+}
+
+// perIP rejects requests from source IPs exceeding their token bucket.
+// It must come before authentication, in order to limit failed logins.
+func (r *rateLimits) perIP(c *gin.Context) {
+	r.allow(c, "ip:"+c.ClientIP(), "ip")
+}
+
+// perClient rejects requests from identities exceeding their token bucket.
+// It must come after authentication, in order to find the client identity.
+// Anonymous requests are left to perIP.
+func (r *rateLimits) perClient(c *gin.Context) {
+	if p := principalOf(c); p != nil && len(p.identities) > 0 {
+		r.allow(c, p.identities[0], "client")
+	}
+}
+
+// perClientGrpc is like perClient, for authenticated gRPC calls.
+// It accepts nil r, meaning no limits.
+func (r *rateLimits) perClientGrpc(p *principal) error {
+	if r == nil || p == nil || len(p.identities) == 0 {
+		return nil
+	}
+	if delay := r.reject(p.identities[0], "client"); delay > 0 {
+		return status.Errorf(codes.ResourceExhausted, "too many requests, retry after %v", delay)
+	}
+	return nil
+}
+
+// allow rejects request if the token bucket for key is empty.
+// reason labels the rejection metric.
+func (r *rateLimits) allow(c *gin.Context, key, reason string) {
+	if delay := r.reject(key, reason); delay > 0 {
+		tooManyRequests(c, delay)
+	}
+}
+
+// reject takes a token from the bucket for key. If the bucket is empty,
+// it returns how long the client should wait, otherwise zero.
+func (r *rateLimits) reject(key, reason string) time.Duration {
+	if r.limit == 0 {
+		return 0
+	}
+
+	now := time.Now()
+
+	reservation := r.client(key, now).ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return 0
+	}
+	reservation.CancelAt(now) // return token, we won't wait
+
+	rateLimited.WithLabelValues(reason).Inc()
+	log.Printf("ratelimit: client=%s rejected, retry after %v", key, delay)
+	return delay
+}
+
+func (r *rateLimits) client(key string, now time.Time) *rate.Limiter {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if now.Sub(r.lastPrune) > rateLimiterIdle {
+		for k, cl := range r.clients {
+			if now.Sub(cl.lastSeen) > rateLimiterIdle {
+				delete(r.clients, k)
+			}
+		}
+		r.lastPrune = now
+	}
+
+	cl, found := r.clients[key]
+	if !found {
+		cl = &clientLimiter{limiter: rate.NewLimiter(r.limit, r.burst)}
+		r.clients[key] = cl
+	}
+	cl.lastSeen = now
+	return cl.limiter
+}
+
+// maxInFlight rejects requests exceeding the global concurrency limit,
+// counting watch requests against their own limit.
+func (r *rateLimits) maxInFlight(c *gin.Context) {
+	limit := r.inFlight
+	if isWatch(c) {
+		limit = r.watches
+	}
+	if !limit.acquire(c.ClientIP()) {
+		tooManyRequests(c, time.Second)
+		return
+	}
+	defer limit.release()
+	c.Next()
+}
+
+// concurrencyLimit caps concurrent requests.
+type concurrencyLimit struct {
+	slots  chan struct{} // nil means unlimited
+	gauge  prometheus.Gauge
+	reason string // labels the rejection metric
+}
+
+func newConcurrencyLimit(max int, gauge prometheus.Gauge, reason string) *concurrencyLimit {
+	l := &concurrencyLimit{gauge: gauge, reason: reason}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+	return l
+}
+
+// acquire takes a slot, reporting false when none is left.
+// Every successful acquire must be followed by release.
+func (l *concurrencyLimit) acquire(client string) bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		rateLimited.WithLabelValues(l.reason).Inc()
+		log.Printf("ratelimit: %s limit %d reached, rejecting %s", l.reason, cap(l.slots), client)
+		return false
+	}
+	l.gauge.Inc()
+	return true
+}
+
+func (l *concurrencyLimit) release() {
+	if l.slots == nil {
+		return
+	}
+	<-l.slots
+	l.gauge.Dec()
+}
+
+// unaryInterceptor applies per-IP and in-flight limits to unary gRPC calls.
+func (r *rateLimits) unaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ip := grpcClientIP(ctx)
+	if delay := r.reject("ip:"+ip, "ip"); delay > 0 {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many requests, retry after %v", delay)
+	}
+	if !r.inFlight.acquire(ip) {
+		return nil, status.Error(codes.ResourceExhausted, "too many requests")
+	}
+	defer r.inFlight.release()
+	return handler(ctx, req)
+}
+
+// streamInterceptor applies per-IP and watch limits to gRPC streams,
+// which are all Watch streams.
+func (r *rateLimits) streamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ip := grpcClientIP(ss.Context())
+	if delay := r.reject("ip:"+ip, "ip"); delay > 0 {
+		return status.Errorf(codes.ResourceExhausted, "too many requests, retry after %v", delay)
+	}
+	if !r.watches.acquire(ip) {
+		return status.Error(codes.ResourceExhausted, "too many watches")
+	}
+	defer r.watches.release()
+	return handler(srv, ss)
+}
+
+// grpcClientIP finds source IP of gRPC call.
+func grpcClientIP(ctx context.Context) string {
+	p, found := peer.FromContext(ctx)
+	if !found || p.Addr == nil {
+		return "unknown"
+	}
+	host, _, errSplit := net.SplitHostPort(p.Addr.String())
+	if errSplit != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// isWatch checks for long-poll or Server-Sent Events request.
+func isWatch(c *gin.Context) bool {
+	return c.Query("wait") != "" || strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+func tooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	c.String(http.StatusTooManyRequests, "too many requests")
+	c.Abort()
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseRate(t *testing.T) {
+	for _, data := range []struct {
+		input    string
+		expected float64
+		fail     bool
+	}{
+		{"0", 0, false},
+		{"10", 10, false},
+		{"0.5", 0.5, false},
+		{"-1", 0, true},
+		{"+Inf", 0, true},
+		{"ten", 0, true},
+	} {
+		result, err := parseRate(data.input)
+		if data.fail {
+			if err == nil {
+				t.Errorf("input=%s: expected error", data.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("input=%s: unexpected error: %v", data.input, err)
+			continue
+		}
+		if result != data.expected {
+			t.Errorf("input=%s: expected=%v got=%v", data.input, data.expected, result)
+		}
+	}
+}
+
+func TestRateLimitPerClient(t *testing.T) {
+	limits := newRateLimits(0.001, 2, 0, 0)
+
+	router := gin.New()
+	router.GET("/*anything", limits.perIP, func(c *gin.Context) {
+		if user := c.GetHeader("X-Test-User"); user != "" {
+			c.Set(principalKey, newPrincipal("basic", []string{user}, nil))
+		}
+	}, limits.perClient, func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for i, data := range []struct {
+		remoteAddr string
+		user       string
+		status     int
+	}{
+		{"10.0.0.1:1000", "", http.StatusOK},
+		{"10.0.0.1:1001", "", http.StatusOK},
+		{"10.0.0.1:1002", "", http.StatusTooManyRequests},
+		{"10.0.0.1:1003", "bob", http.StatusTooManyRequests}, // ip limited before auth
+		{"10.0.0.2:1000", "alice", http.StatusOK},            // other ip
+		{"10.0.0.3:1000", "alice", http.StatusOK},
+		{"10.0.0.4:1000", "alice", http.StatusTooManyRequests}, // same identity, other ip
+		{"10.0.0.2:1001", "", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/app-dev.yml", nil)
+		req.RemoteAddr = data.remoteAddr
+		if data.user != "" {
+			req.Header.Set("X-Test-User", data.user)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != data.status {
+			t.Errorf("%d: addr=%s user=%s: expected status %d, got %d",
+				i, data.remoteAddr, data.user, data.status, w.Code)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%d: missing Retry-After", i)
+		}
+	}
+}
+
+func TestRateLimitMaxInFlight(t *testing.T) {
+	limits := newRateLimits(0, 0, 1, 0)
+
+	block := make(chan struct{})
+	entered := make(chan struct{})
+
+	router := gin.New()
+	router.Use(limits.maxInFlight)
+	router.GET("/*anything", func(c *gin.Context) {
+		if c.Query("block") != "" {
+			close(entered)
+			<-block
+		}
+		c.String(http.StatusOK, "ok")
+	})
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	done := make(chan int)
+	go func() { done <- get("/app-dev.yml?block=1") }()
+	<-entered
+
+	if status := get("/app-dev.yml"); status != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 while in flight, got %d", status)
+	}
+	if status := get("/app-dev.yml?wait=0s"); status != http.StatusOK {
+		t.Errorf("watch request must not be limited, got %d", status)
+	}
+
+	close(block)
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("blocked request: expected status 200, got %d", status)
+	}
+
+	if status := get("/app-dev.yml"); status != http.StatusOK {
+		t.Errorf("expected status 200 after release, got %d", status)
+	}
+}
+
+func TestRateLimitUntrustedForwardedFor(t *testing.T) {
+	limits := newRateLimits(0.001, 1, 0, 0)
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(nil); err != nil {
+		t.Fatalf("trusted proxies: %v", err)
+	}
+	router.GET("/*anything", limits.perIP, func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for i, data := range []struct {
+		forwardedFor string
+		status       int
+	}{
+		{"192.168.0.1", http.StatusOK},
+		{"192.168.0.2", http.StatusTooManyRequests}, // spoofed header ignored
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/app-dev.yml", nil)
+		req.RemoteAddr = "10.0.0.1:1000"
+		req.Header.Set("X-Forwarded-For", data.forwardedFor)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != data.status {
+			t.Errorf("%d: forwarded-for=%s: expected status %d, got %d", i, data.forwardedFor, data.status, w.Code)
+		}
+	}
+}
+
+func TestRateLimitGrpcInterceptors(t *testing.T) {
+	limits := newRateLimits(0.001, 1, 1, 1)
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}})
+	other := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1000}})
+
+	ok := func(context.Context, any) (any, error) { return "ok", nil }
+
+	if _, err := limits.unaryInterceptor(ctx, nil, nil, ok); err != nil {
+		t.Errorf("first call: unexpected error: %v", err)
+	}
+	if _, err := limits.unaryInterceptor(ctx, nil, nil, ok); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second call: expected ResourceExhausted, got: %v", err)
+	}
+
+	// in-flight limit
+	blocking := func(context.Context, any) (any, error) {
+		_, err := limits.unaryInterceptor(other, nil, nil, ok)
+		return nil, err
+	}
+	third := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.3"), Port: 1000}})
+	if _, err := limits.unaryInterceptor(third, nil, nil, blocking); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("nested call: expected ResourceExhausted, got: %v", err)
+	}
+
+	// watch limit
+	fourth := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.4"), Port: 1000}})
+	fifth := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 1000}})
+	nestedStream := func(any, grpc.ServerStream) error {
+		return limits.streamInterceptor(nil, testStream{ctx: fifth}, nil, func(any, grpc.ServerStream) error { return nil })
+	}
+	if err := limits.streamInterceptor(nil, testStream{ctx: fourth}, nil, nestedStream); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("nested stream: expected ResourceExhausted, got: %v", err)
+	}
+
+	// per-identity limit
+	p := newPrincipal("token", []string{"ci"}, nil)
+	if err := limits.perClientGrpc(p); err != nil {
+		t.Errorf("first client call: unexpected error: %v", err)
+	}
+	if err := limits.perClientGrpc(p); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second client call: expected ResourceExhausted, got: %v", err)
+	}
+	var none *rateLimits
+	if err := none.perClientGrpc(p); err != nil {
+		t.Errorf("no limits: unexpected error: %v", err)
+	}
+}
+
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s testStream) Context() context.Context { return s.ctx }
+
+func TestRateLimitMaxWatches(t *testing.T) {
+	limits := newRateLimits(0, 0, 0, 1)
+
+	entered := make(chan struct{})
+	block := make(chan struct{})
+
+	router := gin.New()
+	router.Use(limits.maxInFlight)
+	router.GET("/*anything", func(c *gin.Context) {
+		if c.Query("block") != "" {
+			close(entered)
+			<-block
+		}
+		c.String(http.StatusOK, "ok")
+	})
+
+	get := func(path, accept string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	done := make(chan int)
+	go func() { done <- get("/app-dev.yml?block=1", "text/event-stream") }()
+	<-entered
+
+	if status := get("/app-dev.yml?wait=0s", ""); status != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 for long-poll while watch is open, got %d", status)
+	}
+	if status := get("/app-dev.yml", "text/event-stream"); status != http.StatusTooManyRequests {
+		t.Errorf("expected status 429 for SSE while watch is open, got %d", status)
+	}
+	if status := get("/app-dev.yml", ""); status != http.StatusOK {
+		t.Errorf("plain request must not be limited by watches, got %d", status)
+	}
+
+	close(block)
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("blocked watch: expected status 200, got %d", status)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Readiness probe (READINESS_PATH, default /ready, on the health server).
+
+Unlike the liveness probe (HEALTH_PATH), which always succeeds, readiness fails until:
+
+- the backend is reachable,
+- groupcache peers have been discovered,
+- paths listed in WARMUP_PATHS have been loaded into the cache,
+
+and fails again as soon as shutdown begins.
+
+The AMQP refresh channel state is reported, but only fails readiness
+when READINESS_REQUIRE_AMQP=true, since a server without refresh channel
+still serves (possibly stale) config files.
+
+Check details are only reported to requests carrying ADMIN_TOKEN (see admin.go),
+other requests get the status alone.
+*/
+
+// readinessBackendTimeout limits the backend reachability check.
+const readinessBackendTimeout = 2 * time.Second
+
+// backendChecker is implemented by backends able to check reachability.
+type backendChecker interface {
+	check(ctx context.Context) error
+}
+
+type readiness struct {
+	backend     backend
+	peers       func() int // number of discovered peers
+	health      *healthStatus
+	amqp        bool // amqp refresh enabled
+	requireAmqp bool
+	admin       *adminAuth // authorizes check details
+
+	warmupTotal  int
+	warmupLoaded atomic.Int32
+	warmupDone   atomic.Bool
+
+	shuttingDown atomic.Bool
+}
+
+type readinessCheck struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Required bool   `json:"required"`
+	Detail   string `json:"detail"`
+}
+
+type readinessReport struct {
+	Ready  bool             `json:"ready"`
+	Checks []readinessCheck `json:"checks,omitempty"`
+}
+
+func (r *readiness) check(ctx context.Context) readinessReport {
+	checks := []readinessCheck{
+		r.checkShutdown(),
+		r.checkBackend(ctx),
+		r.checkPeers(),
+		r.checkWarmup(),
+	}
+	if r.amqp {
+		checks = append(checks, r.checkAmqp())
+	}
+
+	report := readinessReport{Ready: true, Checks: checks}
+	for _, c := range checks {
+		if c.Required && !c.OK {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+// shutdown makes readiness fail, for traffic to move to other replicas.
+func (r *readiness) shutdown() {
+	r.shuttingDown.Store(true)
+}
+
+func (r *readiness) checkShutdown() readinessCheck {
+	c := readinessCheck{Name: "shutdown", Required: true, OK: true, Detail: "running"}
+	if r.shuttingDown.Load() {
+		c.OK = false
+		c.Detail = "shutting down"
+	}
+	return c
+}
+
+func (r *readiness) checkBackend(ctx context.Context) readinessCheck {
+	c := readinessCheck{Name: "backend", Required: true}
+	checker, isChecker := r.backend.(backendChecker)
+	if !isChecker {
+		c.OK = true
+		c.Detail = "not checked"
+		return c
+	}
+	ctx, cancel := context.WithTimeout(ctx, readinessBackendTimeout)
+	defer cancel()
+	if err := checker.check(ctx); err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	c.OK = true
+	c.Detail = "reachable"
+	return c
+}
+
+func (r *readiness) checkPeers() readinessCheck {
+	n := r.peers()
+	return readinessCheck{
+		Name:     "peers",
+		OK:       n > 0,
+		Required: true,
+		Detail:   fmt.Sprintf("%d peers", n),
+	}
+}
+
+func (r *readiness) checkWarmup() readinessCheck {
+	return readinessCheck{
+		Name:     "warmup",
+		OK:       r.warmupDone.Load(),
+		Required: true,
+		Detail:   fmt.Sprintf("%d/%d paths processed", r.warmupLoaded.Load(), r.warmupTotal),
+	}
+}
+
+func (r *readiness) checkAmqp() readinessCheck {
+	c := readinessCheck{Name: "amqp", Required: r.requireAmqp, OK: true, Detail: "connected"}
+	if reason, degraded := r.health.isDegraded("amqp"); degraded {
+		c.OK = false
+		c.Detail = reason
+	}
+	return c
+}
+
+func (r *readiness) handle(c *gin.Context) {
+	report := r.check(c.Request.Context())
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	if !r.admin.authorized(c) {
+		report.Checks = nil
+	}
+	c.JSON(status, report)
+}
+
+// warmupAttempts limits retries for each warm-up path,
+// so that a broken path cannot hold readiness forever.
+const warmupAttempts = 5
+
+// warmUp loads paths into the cache, after peers are discovered,
+// in order to route every path to its owner peer.
+// Paths missing in the backend are skipped, other errors are retried.
+func (r *readiness) warmUp(ctx context.Context, configs *configServer, paths []string, retry time.Duration) {
+	for r.peers() == 0 {
+		time.Sleep(retry)
+	}
+
+	for _, path := range paths {
+		for attempt := 1; ; attempt++ {
+			_, err := configs.get(ctx, path)
+			if err == nil {
+				break
+			}
+			if errBackend, isBackend := err.(backendError); isBackend && errBackend.status == http.StatusNotFound {
+				log.Printf("warmup: path='%s': not found, skipping", path)
+				break
+			}
+			if attempt >= warmupAttempts {
+				log.Printf("warmup: path='%s': %v, giving up after %d attempts", path, err, attempt)
+				break
+			}
+			log.Printf("warmup: path='%s': %v, retrying in %v", path, err, retry)
+			time.Sleep(retry)
+		}
+		r.warmupLoaded.Add(1)
+	}
+
+	log.Printf("warmup: %d paths processed", len(paths))
+	r.warmupDone.Store(true)
+}
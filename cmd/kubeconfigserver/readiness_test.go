@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type fakeCheckedBackend struct {
+	err error
+}
+
+func (b *fakeCheckedBackend) fetch(_ context.Context, _ string) ([]byte, error) {
+	return nil, b.err
+}
+
+func (b *fakeCheckedBackend) check(_ context.Context) error {
+	return b.err
+}
+
+func TestReadiness(t *testing.T) {
+	storage := &fakeCheckedBackend{}
+	peers := 0
+	health := newHealthStatus()
+
+	r := &readiness{
+		backend:     storage,
+		peers:       func() int { return peers },
+		health:      health,
+		amqp:        true,
+		admin:       &adminAuth{token: "s3cr3t"},
+		warmupTotal: 0,
+	}
+
+	router := gin.New()
+	router.GET("/ready", r.handle)
+
+	probe := func() (int, readinessReport) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		router.ServeHTTP(w, req)
+		var report readinessReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("json: %v: %s", err, w.Body.String())
+		}
+		return w.Code, report
+	}
+
+	for _, data := range []struct {
+		name        string
+		peers       int
+		warm        bool
+		backendErr  error
+		amqpDown    bool
+		requireAmqp bool
+		status      int
+	}{
+		{"no peers", 0, true, nil, false, false, http.StatusServiceUnavailable},
+		{"not warm", 1, false, nil, false, false, http.StatusServiceUnavailable},
+		{"backend down", 1, true, errors.New("connection refused"), false, false, http.StatusServiceUnavailable},
+		{"ready", 1, true, nil, false, false, http.StatusOK},
+		{"amqp down optional", 1, true, nil, true, false, http.StatusOK},
+		{"amqp down required", 1, true, nil, true, true, http.StatusServiceUnavailable},
+	} {
+		peers = data.peers
+		r.warmupDone.Store(data.warm)
+		storage.err = data.backendErr
+		r.requireAmqp = data.requireAmqp
+		if data.amqpDown {
+			health.degrade("amqp", "refresh channel closed")
+		} else {
+			health.recover("amqp")
+		}
+
+		status, report := probe()
+		if status != data.status {
+			t.Errorf("%s: expected status %d, got %d: %+v", data.name, data.status, status, report)
+		}
+		if report.Ready != (data.status == http.StatusOK) {
+			t.Errorf("%s: ready=%t inconsistent with status %d", data.name, report.Ready, status)
+		}
+		if len(report.Checks) != 5 {
+			t.Errorf("%s: expected 5 checks, got %d", data.name, len(report.Checks))
+		}
+	}
+
+	// kubelet gets the status without details
+	r.requireAmqp = false
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"ready":true}` {
+		t.Errorf("unauthorized probe: expected status alone, got %d: %s", w.Code, w.Body.String())
+	}
+
+	r.shutdown()
+	if status, report := probe(); status != http.StatusServiceUnavailable {
+		t.Errorf("shutting down: expected status 503, got %d: %+v", status, report)
+	}
+}
+
+func TestReadinessWarmUp(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app-dev.yml"), []byte("a: 1\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	tracer := trace.NewNoopTracerProvider().Tracer("test")
+	storage := newBackendDir(tracer, dir, "", 0)
+	groups := &cacheGroups{}
+	groups.add("readiness-test", 1<<20, "", storage)
+
+	configs := &configServer{
+		tracer: tracer,
+		groups: groups,
+		keys:   newTable(),
+		cache:  true,
+	}
+
+	paths := []string{"/app-dev.yml", "/missing.yml"}
+
+	r := &readiness{
+		backend:     storage,
+		peers:       func() int { return 1 },
+		health:      newHealthStatus(),
+		warmupTotal: len(paths),
+	}
+
+	if c := r.checkWarmup(); c.OK {
+		t.Errorf("warmup reported ok before running: %+v", c)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.warmUp(context.Background(), configs, paths, time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("warmup did not finish")
+	}
+
+	if c := r.checkWarmup(); !c.OK || c.Detail != "2/2 paths processed" {
+		t.Errorf("unexpected warmup check: %+v", c)
+	}
+	if keys := configs.keys.match("app"); len(keys) != 1 || keys[0] != "/app-dev.yml" {
+		t.Errorf("unexpected cached keys: %v", keys)
+	}
+	if c := r.checkBackend(context.Background()); !c.OK {
+		t.Errorf("dir backend: %+v", c)
+	}
+}
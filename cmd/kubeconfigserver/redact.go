@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Secret redaction.
+
+With REDACT=true, values whose keys contain any of REDACT_KEYS (case-insensitive,
+default "password,secret,token") are masked in YAML, properties and JSON responses,
+unless the client identity is listed in REDACT_PRIVILEGED (see auth.go for identities),
+like "jwt:group:admins".
+Files without a known format are served unchanged.
+
+Request query parameters matching REDACT_KEYS are always masked in access logs.
+*/
+
+const redactMask = "******"
+
+type redactor struct {
+	keys       []string // lowercase
+	privileged map[string]struct{}
+}
+
+func newRedactor(keys, privileged []string) *redactor {
+	r := &redactor{privileged: map[string]struct{}{}}
+	for _, k := range keys {
+		r.keys = append(r.keys, strings.ToLower(k))
+	}
+	for _, id := range privileged {
+		r.privileged[id] = struct{}{}
+	}
+	return r
+}
+
+// sensitive checks if key holds secret value.
+func (r *redactor) sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range r.keys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivileged checks if principal may read secrets.
+func (r *redactor) isPrivileged(p *principal) bool {
+	if p == nil {
+		return false
+	}
+	for _, id := range p.identities {
+		if _, found := r.privileged[id]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// redact masks secret values in body, according to the format of path.
+func (r *redactor) redact(path string, body []byte) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return r.redactYAML(body)
+	case ".properties":
+		return r.redactProperties(body), nil
+	case ".json":
+		return r.redactJSON(body)
+	case "":
+		if json.Valid(body) {
+			return r.redactJSON(body) // spring environment /app/profile
+		}
+	}
+	return body, nil
+}
+
+func (r *redactor) redactJSON(body []byte) ([]byte, error) {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("redact json: %w", err)
+	}
+	return json.Marshal(r.redactValue(doc))
+}
+
+func (r *redactor) redactValue(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		for k, item := range value {
+			if r.sensitive(k) {
+				value[k] = maskValue(item)
+				continue
+			}
+			value[k] = r.redactValue(item)
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = r.redactValue(item)
+		}
+	}
+	return v
+}
+
+// maskValue masks all leaf values, keeping structure.
+func maskValue(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		for k, item := range value {
+			value[k] = maskValue(item)
+		}
+		return value
+	case []any:
+		for i, item := range value {
+			value[i] = maskValue(item)
+		}
+		return value
+	}
+	return redactMask
+}
+
+func (r *redactor) redactYAML(body []byte) ([]byte, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(body))
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("redact yaml: %w", err)
+		}
+		r.redactNode(&doc)
+		if err := enc.Encode(&doc); err != nil {
+			return nil, fmt.Errorf("redact yaml: %w", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("redact yaml: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func (r *redactor) redactNode(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			r.redactNode(c)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if r.sensitive(n.Content[i].Value) {
+				maskNode(n.Content[i+1])
+				continue
+			}
+			r.redactNode(n.Content[i+1])
+		}
+	}
+}
+
+// maskNode masks all scalar values under n, keeping structure.
+func maskNode(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.ScalarNode, yaml.AliasNode:
+		n.Kind = yaml.ScalarNode
+		n.Alias = nil
+		n.Tag = "!!str"
+		n.Style = 0
+		n.Value = redactMask
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			maskNode(n.Content[i])
+		}
+	case yaml.SequenceNode, yaml.DocumentNode:
+		for _, c := range n.Content {
+			maskNode(c)
+		}
+	}
+}
+
+// redactProperties masks values in Java properties format: key=value, key: value or key value
+func (r *redactor) redactProperties(body []byte) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+	var masking, continued bool
+	for scanner.Scan() {
+		line := scanner.Text()
+		if continued {
+			// continuation line of previous value
+			continued = strings.HasSuffix(line, `\`)
+			if !masking {
+				out.WriteString(line)
+				out.WriteByte('\n')
+			}
+			masking = masking && continued
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+			out.WriteString(line)
+			out.WriteByte('\n')
+			continue
+		}
+		continued = strings.HasSuffix(line, `\`)
+		key, sep := propertyKey(line)
+		if r.sensitive(key) {
+			out.WriteString(line[:sep])
+			out.WriteString(redactMask)
+			out.WriteByte('\n')
+			masking = continued
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// propertyKey finds key of properties line, and the position where value starts.
+func propertyKey(line string) (string, int) {
+	start := len(line) - len(strings.TrimLeft(line, " \t\f"))
+	end := start
+	for end < len(line) {
+		c := line[end]
+		if c == '\\' {
+			end += 2 // escaped char
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			break
+		}
+		end++
+	}
+	end = min(end, len(line))
+	key := line[start:end]
+
+	// skip separator: whitespace, then optional = or :, then whitespace
+	value := end
+	for value < len(line) && (line[value] == ' ' || line[value] == '\t' || line[value] == '\f') {
+		value++
+	}
+	if value < len(line) && (line[value] == '=' || line[value] == ':') {
+		value++
+	}
+	for value < len(line) && (line[value] == ' ' || line[value] == '\t' || line[value] == '\f') {
+		value++
+	}
+	return key, value
+}
+
+// redactQuery masks query parameters matching sensitive keys in request path.
+func (r *redactor) redactQuery(path string) string {
+	p, rawQuery, found := strings.Cut(path, "?")
+	if !found {
+		return path
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return p + "?" + redactMask
+	}
+	changed := false
+	for k, v := range values {
+		if r.sensitive(k) {
+			for i := range v {
+				v[i] = redactMask
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return path
+	}
+	return p + "?" + values.Encode()
+}
+
+// logFormatter is the gin default log format, with sensitive query parameters masked.
+func (r *redactor) logFormatter(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		r.redactQuery(param.Path),
+		param.ErrorMessage,
+	)
+}
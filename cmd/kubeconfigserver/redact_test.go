@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRedact(t *testing.T) {
+	r := newRedactor([]string{"password", "secret", "token"}, nil)
+
+	for _, data := range []struct {
+		path     string
+		input    string
+		expected string
+	}{
+		{
+			"/app-dev.yml",
+			"db:\n  user: app\n  password: s3cr3t\nclientSecrets:\n  - a\n  - b\nlist:\n  - apiToken: t1\n",
+			"db:\n  user: app\n  password: '******'\nclientSecrets:\n  - '******'\n  - '******'\nlist:\n  - apiToken: '******'\n",
+		},
+		{
+			"/app-dev.yml",
+			"a: 1\n---\nPASSWORD: x\n",
+			"a: 1\n---\nPASSWORD: '******'\n",
+		},
+		{
+			"/app-dev.properties",
+			"# comment password=x\ndb.user=app\ndb.password = s3cr3t\napi.token: t1\nkey.secret long \\\n  continued\nother=1\n",
+			"# comment password=x\ndb.user=app\ndb.password = ******\napi.token: ******\nkey.secret ******\nother=1\n",
+		},
+		{
+			"/app-dev.json",
+			`{"db":{"password":"x","user":"app"},"tokens":["a","b"],"n":1}`,
+			`{"db":{"password":"******","user":"app"},"n":1,"tokens":["******","******"]}`,
+		},
+		{
+			"/app/dev",
+			`{"name":"app","propertySources":[{"name":"app.yml","source":{"spring.datasource.password":"x","server.port":8080}}]}`,
+			`{"name":"app","propertySources":[{"name":"app.yml","source":{"server.port":8080,"spring.datasource.password":"******"}}]}`,
+		},
+		{
+			"/app-dev.txt",
+			"password=x\n",
+			"password=x\n",
+		},
+	} {
+		result, err := r.redact(data.path, []byte(data.input))
+		if err != nil {
+			t.Errorf("path=%s: error: %v", data.path, err)
+			continue
+		}
+		if string(result) != data.expected {
+			t.Errorf("path=%s:\nexpected:\n%s\ngot:\n%s", data.path, data.expected, result)
+		}
+	}
+
+	if _, err := r.redact("/broken.yml", []byte("a: [")); err == nil {
+		t.Errorf("broken yaml: expected error")
+	}
+}
+
+func TestRedactPrivileged(t *testing.T) {
+	r := newRedactor([]string{"password"}, []string{"jwt:group:admins"})
+	for _, data := range []struct {
+		p        *principal
+		expected bool
+	}{
+		{nil, false},
+		{newPrincipal("basic", []string{"alice"}, nil), false},
+		{newPrincipal("jwt", []string{"bob"}, []string{"admins"}), true},
+		{newPrincipal("k8s", []string{"bob"}, []string{"admins"}), false},
+	} {
+		if result := r.isPrivileged(data.p); result != data.expected {
+			t.Errorf("principal=%v expected=%t got=%t", data.p, data.expected, result)
+		}
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	r := newRedactor([]string{"password", "secret", "token"}, nil)
+	for _, data := range []struct {
+		path     string
+		expected string
+	}{
+		{"/monitor", "/monitor"},
+		{"/monitor?token=abc", "/monitor?token=%2A%2A%2A%2A%2A%2A"},
+		{"/app.yml?wait=30s&version=1", "/app.yml?wait=30s&version=1"},
+	} {
+		result := r.redactQuery(data.path)
+		if result != data.expected {
+			t.Errorf("path=%s expected=%s got=%s", data.path, data.expected, result)
+		}
+		if strings.Contains(result, "abc") {
+			t.Errorf("path=%s: secret leaked: %s", data.path, result)
+		}
+	}
+}
+
+func TestRedactCompressed(t *testing.T) {
+	dir := t.TempDir()
+	content := "password: s3cr3t\npadding: " + strings.Repeat("x", 100) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "app-dev.yml"), []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	tracer := trace.NewNoopTracerProvider().Tracer("test")
+	groups := &cacheGroups{compressMinSize: 10}
+	groups.add("redact-test", 1<<20, "", newBackendDir(tracer, dir, "", 0))
+
+	s := &configServer{
+		tracer: tracer,
+		groups: groups,
+		keys:   newTable(),
+		cache:  true,
+		redact: newRedactor([]string{"password"}, nil),
+	}
+
+	router := gin.New()
+	router.GET("/*anything", s.handle)
+
+	req := httptest.NewRequest(http.MethodGet, "/app-dev.yml", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status: %d", w.Code)
+	}
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("redacted response must not be sent compressed")
+	}
+	if body := w.Body.String(); strings.Contains(body, "s3cr3t") || !strings.Contains(body, redactMask) {
+		t.Errorf("secret not redacted: %q", body)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+/*
+Re-publish refresh events to downstream clients after invalidation.
+
+Once cache keys have been removed and re-fetched, a Spring Cloud Bus
+RefreshRemoteApplicationEvent is published, so that clients refresh
+from the now fresh cache instead of racing the invalidation.
+
+Events can be delivered to a webhook, to an AMQP exchange and to a Kafka topic.
+Events carry originService "<app>:<hostname>", and events from our own origin
+are ignored when consumed back from AMQP or Kafka. Hence events can be
+re-published into the springCloudBus exchange or topic, reaching Spring
+Cloud Bus clients directly.
+*/
+
+// changeEvent is a Spring Cloud Bus RefreshRemoteApplicationEvent with the refreshed keys.
+type changeEvent struct {
+	Type               string   `json:"type"`
+	Timestamp          int64    `json:"timestamp"`
+	OriginService      string   `json:"originService"`
+	DestinationService string   `json:"destinationService"`
+	ID                 string   `json:"id"`
+	Keys               []string `json:"keys,omitempty"`
+}
+
+type publisher interface {
+	publish(ctx context.Context, body []byte) error
+	String() string
+}
+
+type republisher struct {
+	origin     string
+	publishers []publisher
+}
+
+func newRepublisher(origin string, config appConfig) *republisher {
+	r := &republisher{origin: origin}
+
+	if config.republishWebhookURL != "" {
+		r.publishers = append(r.publishers, &webhookPublisher{url: config.republishWebhookURL})
+	}
+
+	if config.republishAmqpExchange != "" {
+		r.publishers = append(r.publishers, &amqpPublisher{
+			url:      config.refreshAmqpURL,
+			exchange: config.republishAmqpExchange,
+		})
+	}
+
+	if config.republishKafkaTopic != "" && len(config.kafkaBrokers) > 0 {
+		r.publishers = append(r.publishers, &kafkaPublisher{
+			writer: &kafka.Writer{
+				Addr:     kafka.TCP(config.kafkaBrokers...),
+				Topic:    config.republishKafkaTopic,
+				Balancer: &kafka.LeastBytes{},
+			},
+		})
+	}
+
+	for _, p := range r.publishers {
+		log.Printf("republish: origin=%s publisher: %s", origin, p)
+	}
+
+	return r
+}
+
+// ownEvent checks if origin belongs to any replica of ours.
+func ownEvent(me, origin string) bool {
+	return strings.HasPrefix(origin, me+":")
+}
+
+func (r *republisher) enabled() bool {
+	return r != nil && len(r.publishers) > 0
+}
+
+func (r *republisher) republish(ctx context.Context, destination string, keys []string) {
+	event := changeEvent{
+		Type:               "RefreshRemoteApplicationEvent",
+		Timestamp:          time.Now().UnixMilli(),
+		OriginService:      r.origin,
+		DestinationService: destination,
+		ID:                 uuid.NewString(),
+		Keys:               keys,
+	}
+
+	body, errJSON := json.Marshal(event)
+	if errJSON != nil {
+		log.Printf("republish: destination='%s' json error: %v", destination, errJSON)
+		return
+	}
+
+	for _, p := range r.publishers {
+		if err := p.publish(ctx, body); err != nil {
+			log.Printf("republish: %s: destination='%s' id=%s error: %v", p, destination, event.ID, err)
+			continue
+		}
+		log.Printf("republish: %s: destination='%s' id=%s keys=%d", p, destination, event.ID, len(keys))
+	}
+}
+
+type webhookPublisher struct {
+	url string
+}
+
+func (p *webhookPublisher) String() string {
+	return "webhook:" + p.url
+}
+
+func (p *webhookPublisher) publish(ctx context.Context, body []byte) error {
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if errReq != nil {
+		return errReq
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+		Timeout:   10 * time.Second,
+	}
+	resp, errPost := client.Do(req)
+	if errPost != nil {
+		return errPost
+	}
+	defer resp.Body.Close()
+	if !httpSuccess(resp.StatusCode) {
+		return fmt.Errorf("bad status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type amqpPublisher struct {
+	url      string
+	exchange string
+
+	mutex   sync.Mutex
+	conn    *amqp.Connection
+	channel *amqp.Channel
+}
+
+func (p *amqpPublisher) String() string {
+	return "amqp:" + p.exchange
+}
+
+func (p *amqpPublisher) publish(ctx context.Context, body []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.channel == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	msg := amqp.Publishing{
+		ContentType: "application/json",
+		Timestamp:   time.Now(),
+		Body:        body,
+	}
+
+	if err := p.channel.PublishWithContext(ctx, p.exchange, "#", false, false, msg); err != nil {
+		p.close() // force reconnect on next publish
+		return err
+	}
+
+	return nil
+}
+
+func (p *amqpPublisher) connect() error {
+	conn, errDial := amqp.Dial(p.url)
+	if errDial != nil {
+		return errDial
+	}
+	ch, errChannel := conn.Channel()
+	if errChannel != nil {
+		conn.Close()
+		return errChannel
+	}
+	if err := ch.ExchangeDeclare(p.exchange, "topic", true, false, false, false, nil); err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn = conn
+	p.channel = ch
+	return nil
+}
+
+func (p *amqpPublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn = nil
+	p.channel = nil
+}
+
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func (p *kafkaPublisher) String() string {
+	return "kafka:" + p.writer.Topic
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, body []byte) error {
+	return p.writer.WriteMessages(ctx, kafka.Message{Value: body})
+}
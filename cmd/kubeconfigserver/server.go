@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 /*
@@ -32,9 +35,7 @@ func (s *serverHttp) getServer() *http.Server {
 }
 */
 
-func (s *serverHTTP) shutdown(timeout time.Duration) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+func (s *serverHTTP) shutdown(ctx context.Context) {
 	if err := s.server.Shutdown(ctx); err != nil {
 		log.Printf("shutdown error: %v", err)
 	}
@@ -45,6 +46,13 @@ type serverGin struct {
 	router *gin.Engine
 }
 
+func (s *serverGin) listenAndServe() error {
+	if s.server.TLSConfig != nil {
+		return s.server.ListenAndServeTLS("", "") // certificate from TLSConfig.GetCertificate
+	}
+	return s.server.ListenAndServe()
+}
+
 func newServerGin(addr string) *serverGin {
 	r := gin.New()
 	return &serverGin{
@@ -59,10 +67,52 @@ func (s *serverGin) getServer() *http.Server {
 }
 */
 
-func (s *serverGin) shutdown(timeout time.Duration) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+func (s *serverGin) shutdown(ctx context.Context) {
 	if err := s.server.Shutdown(ctx); err != nil {
 		log.Printf("shutdown error: %v", err)
 	}
 }
+
+type serverGrpc struct {
+	addr   string
+	server *grpc.Server
+}
+
+// newServerGrpc creates gRPC server, with TLS if tlsConfig is not nil.
+// Calls are subject to limits.
+func newServerGrpc(addr string, tlsConfig *tls.Config, limits *rateLimits) *serverGrpc {
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(limits.unaryInterceptor),
+		grpc.StreamInterceptor(limits.streamInterceptor),
+	}
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	return &serverGrpc{
+		addr:   addr,
+		server: grpc.NewServer(options...),
+	}
+}
+
+func (s *serverGrpc) listenAndServe() error {
+	lis, errListen := net.Listen("tcp", s.addr)
+	if errListen != nil {
+		return errListen
+	}
+	return s.server.Serve(lis)
+}
+
+// shutdown waits for pending RPCs up to timeout, then cancels remaining ones, like watch streams.
+func (s *serverGrpc) shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("shutdown: grpc server: %v, forcing stop", ctx.Err())
+		s.server.Stop()
+	}
+}
@@ -41,21 +41,137 @@ func (t *table) match(app string) []string {
 	return keys
 }
 
-func refreshMatch(app, key string) bool {
-	app = strings.TrimSuffix(app, ":**")    // "config:file2:**" -> "config:file2"
-	app = strings.Replace(app, ":", "-", 1) // "config:file2" -> "config-file2"
-
-	// "/path/to/config-file1-default.yml,config-file2-default.yml,config-file3-default.yml" ->
-	// "config-file1-default.yml,config-file2-default.yml,config-file3-default.yml"
-	base := filepath.Base(key)
-
-	// "config-file1-default.yml,config-file2-default.yml,config-file3-default.yml" ->
-	// "config-file1-default.yml"
-	keyFiles := strings.Split(base, ",")
-	for _, kf := range keyFiles {
-		if strings.HasPrefix(kf, app) {
+// refreshMatch checks if refresh destination matches cache key.
+//
+// Destinations follow Spring Cloud Bus pattern semantics, with segments
+// separated by ":", where "*" matches a single segment (and works as
+// a glob within a segment) and "**" matches any number of segments.
+// A destination without ":" is an application name, hence "app" means "app:**".
+//
+// Examples: "app:**", "app:profile:**", "app:*", "*:dev:**", "*"
+//
+// The destination is matched against application:profile[:label]
+// parsed from each file referenced by the key. See parseKey.
+func refreshMatch(destination, key string) bool {
+	pattern := destinationPattern(destination)
+	for _, id := range parseKey(key) {
+		if matchSegments(pattern, id) {
 			return true
 		}
 	}
 	return false
 }
+
+// destinationPattern splits destination into pattern segments.
+// A destination without ":" is an application name, hence "app" means "app:**".
+func destinationPattern(destination string) []string {
+	if !strings.Contains(destination, ":") {
+		destination += ":**"
+	}
+	return strings.Split(destination, ":")
+}
+
+// matchSegments matches ant-style pattern segments against id segments.
+func matchSegments(pattern, id []string) bool {
+	if len(pattern) == 0 {
+		return len(id) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(id); i++ {
+			if matchSegments(pattern[1:], id[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(id) == 0 {
+		return false
+	}
+	if matched, err := filepath.Match(pattern[0], id[0]); err != nil || !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], id[1:])
+}
+
+// parseKey finds application, profile and optional label for every
+// file referenced by key. Each result is either [application profile]
+// or [application profile label].
+//
+// Supported keys, following Spring Cloud Config Server paths:
+//
+// "/app/dev" -> [app dev]
+//
+// "/app/dev,prod/main" -> [app dev main] [app prod main]
+//
+// "/app/dev/1.0.x" -> [app dev 1.0.x]
+//
+// "/app-dev.yml" -> [app dev]
+//
+// "/main/app-dev.yml" -> [app dev main]
+//
+// "/path/to/config-file1-default.yml,config-file2-default.yml" -> [config-file1 default] [config-file2 default]
+//
+// File names are split at the last "-", since application names often contain "-" but profiles rarely do.
+// Files without "-" get profile "default".
+// Files are told apart from labels by known config extensions (see defaultContentTypes).
+func parseKey(key string) [][]string {
+	segments := strings.FieldsFunc(key, func(c rune) bool { return c == '/' })
+	if len(segments) == 0 {
+		return nil
+	}
+
+	base := segments[len(segments)-1]
+
+	if len(segments) > 1 && len(segments) < 4 && !isConfigFile(base) {
+		// "/app/profiles[/label]"
+		app := segments[0]
+		var label []string
+		if len(segments) == 3 {
+			label = segments[2:]
+		}
+		var ids [][]string
+		for _, profile := range strings.Split(segments[1], ",") {
+			ids = append(ids, append([]string{app, profile}, label...))
+		}
+		return ids
+	}
+
+	// "[/label]/app-profile.ext"
+	var label []string
+	if len(segments) == 2 {
+		label = segments[:1]
+	}
+
+	var ids [][]string
+	for _, file := range strings.Split(base, ",") {
+		stem := strings.TrimSuffix(file, filepath.Ext(file))
+		if stem == "" {
+			continue
+		}
+		app, profile := stem, "default"
+		if i := strings.LastIndex(stem, "-"); i > 0 && i < len(stem)-1 {
+			app, profile = stem[:i], stem[i+1:]
+		}
+		ids = append(ids, append([]string{app, profile}, label...))
+	}
+	return ids
+}
+
+// isConfigFile checks if every comma-separated file name in base has a known config extension.
+func isConfigFile(base string) bool {
+	for _, file := range strings.Split(base, ",") {
+		if _, found := defaultContentTypes[filepath.Ext(file)]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+// nextDash finds next "-" in s after position i.
+func nextDash(s string, i int) int {
+	j := strings.Index(s[i+1:], "-")
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
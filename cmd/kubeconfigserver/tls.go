@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+TLS for the application and gRPC servers.
+
+TLS_CERT_FILE and TLS_KEY_FILE enable TLS. The files are reloaded whenever
+modified, hence certificates renewed by cert-manager are picked up without restart.
+
+TLS_CLIENT_CA_FILE enables client certificate verification (mutual TLS).
+Client certificates are required, unless TLS_CLIENT_CERT_OPTIONAL=true.
+*/
+
+// certReloadInterval limits how often certificate files are checked for modification.
+const certReloadInterval = 10 * time.Second
+
+// certReloader provides certificate loaded from files, reloading them whenever modified.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex     sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time // latest modification time of cert and key files
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		info, errStat := os.Stat(f)
+		if errStat != nil {
+			return latest, errStat
+		}
+		if t := info.ModTime(); t.After(latest) {
+			latest = t
+		}
+	}
+	return latest, nil
+}
+
+// load must be called with mutex held, except from constructor.
+func (r *certReloader) load() error {
+	modTime, errStat := r.filesModTime()
+	if errStat != nil {
+		return errStat
+	}
+	cert, errLoad := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if errLoad != nil {
+		return errLoad
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	r.lastCheck = time.Now()
+	log.Printf("tls: loaded certificate from cert=%s key=%s", r.certFile, r.keyFile)
+	return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate.
+// Whenever reloading fails, the previous certificate is kept.
+func (r *certReloader) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if time.Since(r.lastCheck) < certReloadInterval {
+		return r.cert, nil
+	}
+	r.lastCheck = time.Now()
+
+	modTime, errStat := r.filesModTime()
+	if errStat != nil {
+		log.Printf("tls: keeping current certificate: %v", errStat)
+		return r.cert, nil
+	}
+	if !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	if err := r.load(); err != nil {
+		log.Printf("tls: keeping current certificate: reload: %v", err)
+	}
+	return r.cert, nil
+}
+
+// newTLSConfig creates TLS config for servers.
+// It returns nil when TLS is disabled.
+func newTLSConfig(config appConfig) (*tls.Config, error) {
+	if config.tlsCertFile == "" && config.tlsKeyFile == "" {
+		return nil, nil
+	}
+
+	reloader, errCert := newCertReloader(config.tlsCertFile, config.tlsKeyFile)
+	if errCert != nil {
+		return nil, fmt.Errorf("certificate: %w", errCert)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	if config.tlsClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, errRead := os.ReadFile(config.tlsClientCAFile)
+	if errRead != nil {
+		return nil, fmt.Errorf("client ca: %w", errRead)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("client ca: no certificate found in %s", config.tlsClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+
+	if config.tlsClientCertOptional {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	} else {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// certIdentities lists identities from verified client certificate:
+// subject common name, DNS names, URIs (like SPIFFE IDs) and email addresses.
+func certIdentities(state *tls.ConnectionState) []string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := state.VerifiedChains[0][0]
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	ids = append(ids, cert.EmailAddresses...)
+	return ids
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes self-signed certificate and key for commonName into dir.
+func writeTestCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, errKey := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if errKey != nil {
+		t.Fatalf("key: %v", errKey)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, errCert := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if errCert != nil {
+		t.Fatalf("cert: %v", errCert)
+	}
+	keyDER, errMarshal := x509.MarshalECPrivateKey(key)
+	if errMarshal != nil {
+		t.Fatalf("marshal key: %v", errMarshal)
+	}
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func leafCommonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "first")
+
+	r, errNew := newCertReloader(certFile, keyFile)
+	if errNew != nil {
+		t.Fatalf("new: %v", errNew)
+	}
+
+	cert, _ := r.getCertificate(nil)
+	if cn := leafCommonName(t, cert); cn != "first" {
+		t.Errorf("expected first certificate, got %s", cn)
+	}
+
+	writeTestCert(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, future, future); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+
+	// force check
+	r.lastCheck = time.Time{}
+
+	cert, _ = r.getCertificate(nil)
+	if cn := leafCommonName(t, cert); cn != "second" {
+		t.Errorf("expected reloaded certificate, got %s", cn)
+	}
+
+	// broken files keep current certificate
+	if err := os.WriteFile(certFile, []byte("broken"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	later := future.Add(time.Minute)
+	if err := os.Chtimes(certFile, later, later); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	r.lastCheck = time.Time{}
+
+	cert, _ = r.getCertificate(nil)
+	if cn := leafCommonName(t, cert); cn != "second" {
+		t.Errorf("expected kept certificate, got %s", cn)
+	}
+}
+
+func TestCertIdentities(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/payments/sa/orders")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "orders"},
+		DNSNames:       []string{"orders.payments.svc"},
+		URIs:           []*url.URL{spiffe},
+		EmailAddresses: []string{"orders@example.com"},
+	}
+	state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	ids := certIdentities(state)
+	expected := []string{"orders", "orders.payments.svc", "spiffe://cluster.local/ns/payments/sa/orders", "orders@example.com"}
+	if len(ids) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, ids)
+		}
+	}
+
+	if ids := certIdentities(&tls.ConnectionState{}); ids != nil {
+		t.Errorf("unverified connection: expected no identity, got %v", ids)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+/*
+Kubernetes ServiceAccount bearer tokens verified with the TokenReview API (AUTH_KUBERNETES=true).
+
+The principal identity is the user name, like "k8s:system:serviceaccount:<namespace>:<name>",
+plus groups, like "k8s:group:system:serviceaccounts:<namespace>".
+
+Requires permission to create tokenreviews, for instance by binding
+the service account to ClusterRole system:auth-delegator.
+*/
+
+// tokenReviewCacheTTL limits how long successful token reviews are reused.
+const tokenReviewCacheTTL = time.Minute
+
+type tokenReviewer interface {
+	Create(ctx context.Context, tokenReview *authenticationv1.TokenReview, opts metav1.CreateOptions) (*authenticationv1.TokenReview, error)
+}
+
+type tokenReviewAuth struct {
+	reviewer  tokenReviewer
+	audiences []string
+
+	mutex sync.Mutex
+	cache map[[sha256.Size]byte]tokenReviewEntry
+}
+
+type tokenReviewEntry struct {
+	principal *principal
+	expire    time.Time
+}
+
+func newTokenReviewAuth(audiences []string) (*tokenReviewAuth, error) {
+	config, errConfig := rest.InClusterConfig()
+	if errConfig != nil {
+		return nil, errConfig
+	}
+	clientset, errClient := kubernetes.NewForConfig(config)
+	if errClient != nil {
+		return nil, errClient
+	}
+	log.Printf("auth: kubernetes: token review audiences=%v", audiences)
+	return &tokenReviewAuth{
+		reviewer:  clientset.AuthenticationV1().TokenReviews(),
+		audiences: audiences,
+		cache:     map[[sha256.Size]byte]tokenReviewEntry{},
+	}, nil
+}
+
+func (k *tokenReviewAuth) authenticate(ctx context.Context, authorization string) (*principal, error) {
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found {
+		return nil, nil // not bearer token
+	}
+
+	hash := sha256.Sum256([]byte(token))
+
+	if p, cached := k.cached(hash); cached {
+		return p, nil
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: k.audiences,
+		},
+	}
+
+	result, errReview := k.reviewer.Create(ctx, review, metav1.CreateOptions{})
+	if errReview != nil {
+		return nil, fmt.Errorf("kubernetes: token review: %w", errReview)
+	}
+	if !result.Status.Authenticated {
+		return nil, fmt.Errorf("kubernetes: token not authenticated: %s", result.Status.Error)
+	}
+
+	p := newPrincipal("k8s", []string{result.Status.User.Username}, result.Status.User.Groups)
+
+	k.store(hash, p)
+
+	return p, nil
+}
+
+func (k *tokenReviewAuth) cached(hash [sha256.Size]byte) (*principal, bool) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	e, found := k.cache[hash]
+	if !found || time.Now().After(e.expire) {
+		return nil, false
+	}
+	return e.principal, true
+}
+
+func (k *tokenReviewAuth) store(hash [sha256.Size]byte, p *principal) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	now := time.Now()
+	for h, e := range k.cache {
+		if now.After(e.expire) {
+			delete(k.cache, h)
+		}
+	}
+	k.cache[hash] = tokenReviewEntry{principal: p, expire: now.Add(tokenReviewCacheTTL)}
+}
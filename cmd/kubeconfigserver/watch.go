@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+Watch config files for changes, for clients that can't consume refresh events.
+
+Long-poll: the request blocks until the content differs from version (the ETag
+of a previous response), replying 304 Not Modified after the wait expires.
+
+curl -i 'localhost:8080/myapp-default.yml?wait=30s&version=<etag>'
+
+Server-Sent Events: an event is sent with the current content of each path,
+then another event whenever the content changes. Extra paths are added with
+the query parameter path.
+
+curl -H 'Accept: text/event-stream' 'localhost:8080/myapp-default.yml?path=/other-default.yml'
+
+Watchers are woken up as soon as the key is invalidated on this replica.
+Keys invalidated on other replicas are detected by re-checking the
+content every WATCH_RECHECK.
+
+On shutdown, long-polls reply 304 Not Modified and streams are ended,
+so that clients reconnect to other replicas.
+*/
+
+// errShuttingDown ends watches when the server is shutting down.
+var errShuttingDown = errors.New("server shutting down")
+
+// watchHub wakes up watchers of invalidated keys.
+type watchHub struct {
+	mutex sync.Mutex
+	subs  map[string]map[chan struct{}]struct{} // key => watchers
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{
+		subs:   map[string]map[chan struct{}]struct{}{},
+		closed: make(chan struct{}),
+	}
+}
+
+// close ends all current and future watches.
+func (h *watchHub) close() {
+	h.closeOnce.Do(func() { close(h.closed) })
+}
+
+// subscribe returns channel signaled whenever any of keys is invalidated.
+func (h *watchHub) subscribe(keys []string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	h.mutex.Lock()
+	for _, k := range keys {
+		watchers, found := h.subs[k]
+		if !found {
+			watchers = map[chan struct{}]struct{}{}
+			h.subs[k] = watchers
+		}
+		watchers[ch] = struct{}{}
+	}
+	h.mutex.Unlock()
+	watchClients.Inc()
+	return ch
+}
+
+func (h *watchHub) unsubscribe(keys []string, ch chan struct{}) {
+	h.mutex.Lock()
+	for _, k := range keys {
+		watchers := h.subs[k]
+		delete(watchers, ch)
+		if len(watchers) == 0 {
+			delete(h.subs, k)
+		}
+	}
+	h.mutex.Unlock()
+	watchClients.Dec()
+}
+
+// notify wakes up watchers of key.
+func (h *watchHub) notify(key string) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for ch := range h.subs[key] {
+		select {
+		case ch <- struct{}{}:
+		default: // watcher already signaled
+		}
+	}
+}
+
+// longPoll serves GET /*anything?wait=30s&version=<etag>
+func (s *configServer) longPoll(ctx context.Context, c *gin.Context, span trace.Span, p *principal, path, wait string) {
+	timeout, errWait := time.ParseDuration(wait)
+	if errWait != nil || timeout < 0 {
+		c.String(http.StatusBadRequest, "bad wait: %q", wait)
+		return
+	}
+	timeout = min(timeout, s.watchMaxWait)
+
+	etag := strings.Trim(c.Query("version"), `"`)
+	version := strings.TrimSuffix(etag, gzipETagSuffix) // same version for both encodings
+
+	keys := []string{path}
+	ch := s.watch.subscribe(keys) // subscribe before get, not to miss invalidation
+	defer s.watch.unsubscribe(keys, ch)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	recheck := time.NewTicker(s.watchRecheck)
+	defer recheck.Stop()
+
+	for {
+		entry, errGet := s.read(ctx, path, p)
+		if errGet != nil {
+			sendError(c, span, errGet)
+			return
+		}
+
+		if entry.version() != version {
+			s.send(c, span, path, entry)
+			return
+		}
+
+		select {
+		case <-ch:
+		case <-recheck.C:
+		case <-timer.C:
+			c.Header("ETag", `"`+etag+`"`)
+			c.Status(http.StatusNotModified)
+			return
+		case <-s.watch.closed:
+			c.Header("ETag", `"`+etag+`"`)
+			c.Status(http.StatusNotModified)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watchEvent is the data of Server-Sent Event.
+type watchEvent struct {
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+	Content string `json:"content,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// stream serves GET /*anything with header Accept: text/event-stream
+func (s *configServer) stream(ctx context.Context, c *gin.Context, p *principal, paths []string) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	err := s.watchPaths(ctx, paths, p, func(event watchEvent) error {
+		if err := writeEvent(c, event); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+
+	log.Printf("watch: stream paths=%v: %v", paths, err)
+}
+
+// watchPaths calls send with current content of paths as seen by principal p,
+// then again whenever the content changes.
+// It only returns on error from send, when ctx is done, or on shutdown.
+func (s *configServer) watchPaths(ctx context.Context, paths []string, p *principal, send func(watchEvent) error) error {
+	ch := s.watch.subscribe(paths)
+	defer s.watch.unsubscribe(paths, ch)
+
+	recheck := time.NewTicker(s.watchRecheck)
+	defer recheck.Stop()
+
+	log.Printf("watch: paths=%v", paths)
+
+	last := map[string]string{} // path => last state sent
+
+	for {
+		for _, path := range paths {
+			event := watchEvent{Path: path}
+			entry, errGet := s.read(ctx, path, p)
+			if errGet == nil {
+				var body []byte
+				body, errGet = decompress(entry.encoding, entry.payload)
+				event.Version = entry.version()
+				event.Content = string(body)
+			}
+			if errGet != nil {
+				event = watchEvent{Path: path, Error: errGet.Error()}
+			}
+			state := event.Version + event.Error
+			if state == last[path] {
+				continue // unchanged
+			}
+			last[path] = state
+			if err := send(event); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ch:
+		case <-recheck.C:
+		case <-s.watch.closed:
+			return errShuttingDown
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func writeEvent(c *gin.Context, event watchEvent) error {
+	data, errJSON := json.Marshal(event)
+	if errJSON != nil {
+		return errJSON
+	}
+	name := "change"
+	if event.Error != "" {
+		name = "error"
+	}
+	_, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.Version, name, data)
+	return err
+}
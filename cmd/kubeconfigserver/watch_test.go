@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWatchLongPoll(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app-default.yml")
+	if err := os.WriteFile(file, []byte("a: 1\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	tracer := trace.NewNoopTracerProvider().Tracer("test")
+	storage := newBackendDir(tracer, dir, "", 0)
+	groups := &cacheGroups{}
+	groups.add("watch-test", 1<<20, "", storage)
+	keys := newTable()
+	watch := newWatchHub()
+	inv := &invalidator{groups: groups, keys: keys, watch: watch}
+
+	s := &configServer{
+		tracer:       tracer,
+		groups:       groups,
+		keys:         keys,
+		cache:        true,
+		watch:        watch,
+		watchMaxWait: time.Minute,
+		watchRecheck: time.Minute,
+	}
+
+	router := gin.New()
+	router.GET("/*anything", s.handle)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	const key = "/app-default.yml"
+
+	w := get(key)
+	if w.Code != http.StatusOK || w.Body.String() != "a: 1\n" {
+		t.Fatalf("get: status=%d body=%q", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("get: missing etag")
+	}
+
+	// unchanged content: wait expires
+	w = get(key + "?wait=50ms&version=" + etag)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("long-poll unchanged: expected status %d, got %d", http.StatusNotModified, w.Code)
+	}
+
+	// gzip etag names the same version
+	gzipETag := strings.TrimSuffix(etag, `"`) + gzipETagSuffix + `"`
+	w = get(key + "?wait=50ms&version=" + url.QueryEscape(gzipETag))
+	if w.Code != http.StatusNotModified || w.Header().Get("ETag") != gzipETag {
+		t.Errorf("long-poll gzip etag: status=%d etag=%s", w.Code, w.Header().Get("ETag"))
+	}
+
+	// outdated version: immediate reply
+	w = get(key + "?wait=1m&version=outdated")
+	if w.Code != http.StatusOK || w.Body.String() != "a: 1\n" {
+		t.Errorf("long-poll outdated: status=%d body=%q", w.Code, w.Body.String())
+	}
+
+	// invalidation wakes up watcher
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if err := os.WriteFile(file, []byte("a: 2\n"), 0o644); err != nil {
+			t.Errorf("write: %v", err)
+		}
+		inv.refresh(context.Background(), "test", "app:**")
+	}()
+	w = get(key + "?wait=10s&version=" + etag)
+	if w.Code != http.StatusOK || w.Body.String() != "a: 2\n" {
+		t.Errorf("long-poll changed: status=%d body=%q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Errorf("long-poll changed: etag not updated")
+	}
+
+	w = get(key + "?wait=bad")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad wait: expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// shutdown releases watchers
+	etag = get(key).Header().Get("ETag")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		watch.close()
+	}()
+	begin := time.Now()
+	w = get(key + "?wait=10s&version=" + etag)
+	if w.Code != http.StatusNotModified || time.Since(begin) > 5*time.Second {
+		t.Errorf("long-poll shutdown: status=%d elapsed=%v", w.Code, time.Since(begin))
+	}
+
+	errWatch := s.watchPaths(context.Background(), []string{key}, nil, func(watchEvent) error { return nil })
+	if errWatch != errShuttingDown {
+		t.Errorf("watch after shutdown: expected %v, got %v", errShuttingDown, errWatch)
+	}
+}
+
+func TestWatchHub(t *testing.T) {
+	h := newWatchHub()
+	ch := h.subscribe([]string{"/a", "/b"})
+
+	h.notify("/c")
+	select {
+	case <-ch:
+		t.Errorf("unexpected notification for unwatched key")
+	default:
+	}
+
+	h.notify("/a")
+	h.notify("/b") // coalesced with pending notification
+	select {
+	case <-ch:
+	default:
+		t.Errorf("missing notification")
+	}
+
+	h.unsubscribe([]string{"/a", "/b"}, ch)
+	if len(h.subs) != 0 {
+		t.Errorf("subscriptions left after unsubscribe: %d", len(h.subs))
+	}
+}
@@ -0,0 +1,217 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: config.proto
+
+package configpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ConfigServer_Get_FullMethodName            = "/configserver.ConfigServer/Get"
+	ConfigServer_GetEnvironment_FullMethodName = "/configserver.ConfigServer/GetEnvironment"
+	ConfigServer_Watch_FullMethodName          = "/configserver.ConfigServer/Watch"
+)
+
+// ConfigServerClient is the client API for ConfigServer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ConfigServerClient interface {
+	// Get retrieves config file by path, like HTTP GET /path.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*ConfigFile, error)
+	// GetEnvironment retrieves Spring Cloud Config environment, like HTTP GET /application/profiles/label.
+	GetEnvironment(ctx context.Context, in *EnvironmentRequest, opts ...grpc.CallOption) (*Environment, error)
+	// Watch sends config files on subscription, then again whenever their content changes.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (ConfigServer_WatchClient, error)
+}
+
+type configServerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewConfigServerClient(cc grpc.ClientConnInterface) ConfigServerClient {
+	return &configServerClient{cc}
+}
+
+func (c *configServerClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*ConfigFile, error) {
+	out := new(ConfigFile)
+	err := c.cc.Invoke(ctx, ConfigServer_Get_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *configServerClient) GetEnvironment(ctx context.Context, in *EnvironmentRequest, opts ...grpc.CallOption) (*Environment, error) {
+	out := new(Environment)
+	err := c.cc.Invoke(ctx, ConfigServer_GetEnvironment_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *configServerClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (ConfigServer_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ConfigServer_ServiceDesc.Streams[0], ConfigServer_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &configServerWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ConfigServer_WatchClient interface {
+	Recv() (*ConfigFile, error)
+	grpc.ClientStream
+}
+
+type configServerWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *configServerWatchClient) Recv() (*ConfigFile, error) {
+	m := new(ConfigFile)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ConfigServerServer is the server API for ConfigServer service.
+// All implementations must embed UnimplementedConfigServerServer
+// for forward compatibility
+type ConfigServerServer interface {
+	// Get retrieves config file by path, like HTTP GET /path.
+	Get(context.Context, *GetRequest) (*ConfigFile, error)
+	// GetEnvironment retrieves Spring Cloud Config environment, like HTTP GET /application/profiles/label.
+	GetEnvironment(context.Context, *EnvironmentRequest) (*Environment, error)
+	// Watch sends config files on subscription, then again whenever their content changes.
+	Watch(*WatchRequest, ConfigServer_WatchServer) error
+	mustEmbedUnimplementedConfigServerServer()
+}
+
+// UnimplementedConfigServerServer must be embedded to have forward compatible implementations.
+type UnimplementedConfigServerServer struct {
+}
+
+func (UnimplementedConfigServerServer) Get(context.Context, *GetRequest) (*ConfigFile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedConfigServerServer) GetEnvironment(context.Context, *EnvironmentRequest) (*Environment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEnvironment not implemented")
+}
+func (UnimplementedConfigServerServer) Watch(*WatchRequest, ConfigServer_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedConfigServerServer) mustEmbedUnimplementedConfigServerServer() {}
+
+// UnsafeConfigServerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ConfigServerServer will
+// result in compilation errors.
+type UnsafeConfigServerServer interface {
+	mustEmbedUnimplementedConfigServerServer()
+}
+
+func RegisterConfigServerServer(s grpc.ServiceRegistrar, srv ConfigServerServer) {
+	s.RegisterService(&ConfigServer_ServiceDesc, srv)
+}
+
+func _ConfigServer_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConfigServerServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConfigServer_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConfigServerServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ConfigServer_GetEnvironment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnvironmentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConfigServerServer).GetEnvironment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConfigServer_GetEnvironment_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConfigServerServer).GetEnvironment(ctx, req.(*EnvironmentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ConfigServer_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConfigServerServer).Watch(m, &configServerWatchServer{stream})
+}
+
+type ConfigServer_WatchServer interface {
+	Send(*ConfigFile) error
+	grpc.ServerStream
+}
+
+type configServerWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *configServerWatchServer) Send(m *ConfigFile) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// ConfigServer_ServiceDesc is the grpc.ServiceDesc for ConfigServer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ConfigServer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "configserver.ConfigServer",
+	HandlerType: (*ConfigServerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _ConfigServer_Get_Handler,
+		},
+		{
+			MethodName: "GetEnvironment",
+			Handler:    _ConfigServer_GetEnvironment_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _ConfigServer_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "config.proto",
+}
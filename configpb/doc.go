@@ -0,0 +1,4 @@
+// Package configpb holds the gRPC API for config retrieval and watch.
+package configpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative config.proto